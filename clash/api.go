@@ -0,0 +1,200 @@
+package clash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+	"github.com/go-chi/chi/v5"
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/samber/lo"
+)
+
+// 运行中实例的状态
+type StatusInfo struct {
+	Pid         int                        `json:"pid"`
+	Started     time.Time                  `json:"started"`
+	Uptime      string                     `json:"uptime"`
+	Current     string                     `json:"current"`
+	Preferred   string                     `json:"preferred,omitempty"` //首选的订阅(配置文件中的或手动切换的), 故障转移后与 current 不同
+	Mode        string                     `json:"mode"`
+	Connections int                        `json:"connections"`
+	Upload      int64                      `json:"upload"`
+	Download    int64                      `json:"download"`
+	Subscribe   map[string]*SubscribeState `json:"subscribe,omitempty"`
+	Failover    *Failover                  `json:"failover,omitempty"`
+}
+
+// hlash 自己的接口, 挂在 RESTful API 的 /hlash 下
+func (s *Service) apiRoutes(r chi.Router) {
+	r.Get("/config", s.configHandler)
+	r.Get("/status", s.apiStatus)
+	r.Post("/reload", s.apiReload)
+	r.Post("/update/{name}", s.apiUpdate)
+	r.Post("/switch/{name}", s.apiSwitch)
+	r.Get("/providers", s.apiProviders)
+	r.Post("/providers/update", s.apiProviderUpdate)
+	r.Post("/providers/update/{name}", s.apiProviderUpdate)
+	r.Get("/rules", s.apiRules)
+	r.Post("/rules/reload", s.apiRulesReload)
+}
+
+func (s *Service) apiStatus(w http.ResponseWriter, r *http.Request) {
+	var (
+		state    = s.stateSnapshot()
+		snapshot = statistic.DefaultManager.Snapshot()
+	)
+	s.mu.RLock()
+	preferred, mode := s.preferred, tunnel.Mode()
+	s.mu.RUnlock()
+	apiJson(w, &StatusInfo{
+		Pid:         state.Pid,
+		Started:     state.Started,
+		Uptime:      time.Since(state.Started).Round(time.Second).String(),
+		Current:     state.Current,
+		Preferred:   lo.Ternary(preferred != state.Current, preferred, ""),
+		Mode:        mode.String(),
+		Connections: len(snapshot.Connections),
+		Upload:      snapshot.UploadTotal,
+		Download:    snapshot.DownloadTotal,
+		Subscribe:   state.Subscribe,
+		Failover:    state.Failover,
+	})
+}
+
+// 重新加载 config.yaml, general.yaml, dns.yaml 和当前订阅
+func (s *Service) apiReload(w http.ResponseWriter, r *http.Request) {
+	if err := s.reload(); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	apiJson(w, map[string]string{"current": s.Current()})
+}
+
+// 更新订阅, 更新的是当前订阅时重新加载
+func (s *Service) apiUpdate(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	sub := s.subscribeFind(name)
+	if sub == nil {
+		apiError(w, http.StatusNotFound, fmt.Errorf("订阅 [%s] 不存在", name))
+		return
+	}
+
+	if !s.subscribeUpdate(r.Context(), sub) {
+		msg := "更新失败"
+		if st := s.stateSnapshot().Subscribe[sub.Name]; st != nil && st.Error != "" {
+			msg = st.Error
+		}
+		apiError(w, http.StatusInternalServerError, fmt.Errorf("[%s] %s", sub.Name, msg))
+		return
+	}
+
+	if nameEq(s.Current())(sub) {
+		if err := s.subscribeSwitch(sub.Name); err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	apiJson(w, s.stateSnapshot().Subscribe[sub.Name])
+}
+
+// 切换订阅, 还没有下载时先更新; ?save 时同时写入 config.yaml
+func (s *Service) apiSwitch(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	sub := s.subscribeFind(name)
+	if sub == nil {
+		apiError(w, http.StatusNotFound, fmt.Errorf("订阅 [%s] 不存在", name))
+		return
+	}
+
+	if _, err := os.Stat(s.pathResolve(SUBSCRIBE_DIR, sub.Name+".yaml")); os.IsNotExist(err) && !s.subscribeUpdate(r.Context(), sub) {
+		apiError(w, http.StatusInternalServerError, fmt.Errorf("[%s] 更新失败", sub.Name))
+		return
+	}
+
+	if err := s.subscribeSwitch(sub.Name); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	logs.Infof("[订阅] 切换到 [%s]", sub.Name)
+
+	//手动切换的订阅作为首选, 健康检查不会切换回之前的订阅; 不保存时重新加载后恢复为配置文件中的
+	s.mu.Lock()
+	s.preferred = sub.Name
+	s.mu.Unlock()
+
+	if r.URL.Query().Has("save") {
+		if err := s.SubscribeUse(sub.Name); err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	apiJson(w, map[string]string{"current": sub.Name})
+}
+
+// 重新加载配置, 更新计划按新的配置重新开始; 透明代理, API 和日志的修改需要重启才能生效
+func (s *Service) reload() (err error) {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+
+	if err = s.load(); err != nil {
+		return
+	}
+	current := s.Current()
+	if err = s.subscribeApply(current, true); err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.preferred = current
+	s.mu.Unlock()
+	logs.Infof("[重新加载] 当前订阅 [%s]", current)
+	return
+}
+
+// 加载订阅文件和预设并应用到内核, force 为真时按新的配置重建入站监听
+// 切换订阅时 force 为假, 预设中端口等监听的修改需要重启才能生效
+// 调用时需要持有 switchMu
+func (s *Service) subscribeApply(name string, force bool) (err error) {
+	sub := s.subscribeFind(name)
+	if sub == nil {
+		return fmt.Errorf("[订阅] [%s] 不存在", name)
+	}
+
+	fn := s.pathResolve(SUBSCRIBE_DIR, sub.Name+".yaml")
+	s.providerPrefetch(context.Background(), fn)
+	cfg, err := executor.ParseWithPath(fn)
+	if err != nil {
+		return fmt.Errorf("[订阅] [%s] 加载失败: %w", sub.Name, err)
+	}
+
+	//内核替换节点和模式时没有加锁, 读取 tunnel 的地方需要持有读锁
+	s.mu.Lock()
+	s.clash = cfg
+	s.curSubscribe = sub
+	s.loadGeneral(sub.Name)
+	s.clashOverride(false)
+	executor.ApplyConfig(cfg, force)
+	s.selectedRestore(sub.Name)
+	s.config.Current = sub.Name
+	s.mu.Unlock()
+
+	s.stateCurrent(sub.Name)
+	return
+}
+
+func apiJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+}
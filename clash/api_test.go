@@ -0,0 +1,120 @@
+package clash
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// 运行中同时切换, 重新加载和读取状态, 需要使用 go test -race
+func TestApiConcurrent(t *testing.T) {
+	s := testService(t)
+	if err := s.subscribeSwitch("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.preferred = s.config.Current
+	s.runCtx = ctx
+	s.scheduleStart(ctx)
+	s.mu.Unlock()
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	router := chi.NewRouter()
+	s.apiRoutes(router)
+	request := func(method, path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	requests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/switch/b"},
+		{http.MethodPost, "/switch/a"},
+		{http.MethodPost, "/reload"},
+		{http.MethodGet, "/status"},
+		{http.MethodGet, "/providers"},
+		{http.MethodGet, "/rules"},
+		{http.MethodPost, "/rules/reload"},
+		{http.MethodGet, "/config"},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		for _, it := range requests {
+			wg.Add(1)
+			go func(method, path string) {
+				defer wg.Done()
+				if code := request(method, path); code != http.StatusOK {
+					t.Errorf("%s %s: %d", method, path, code)
+				}
+			}(it.method, it.path)
+		}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			s.selectedSave("PROXY")
+		}()
+		go func() {
+			defer wg.Done()
+			s.mu.RLock()
+			order := s.healthOrder()
+			s.mu.RUnlock()
+			if len(order) != 2 {
+				t.Errorf("healthOrder: %v", order)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if current := s.Current(); current != "a" && current != "b" {
+		t.Errorf("current: %q", current)
+	}
+}
+
+// 重新加载后计划任务使用新的订阅配置
+func TestReloadSchedule(t *testing.T) {
+	s := testService(t)
+	if err := s.subscribeSwitch("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.scheduleStart(ctx)
+	old := s.config.Subscribe[0]
+	s.mu.Unlock()
+	defer func() {
+		cancel()
+		s.wg.Wait()
+	}()
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub := s.config.Subscribe[0]
+	if sub == old {
+		t.Fatal("重新加载后订阅没有替换")
+	}
+	if sub.next.IsZero() || !sub.next.Equal(old.next) {
+		t.Errorf("更新计划未修改时应保留下次执行的时间: %v, 之前 %v", sub.next, old.next)
+	}
+	if s.preferred != "a" {
+		t.Errorf("preferred: %q", s.preferred)
+	}
+}
@@ -0,0 +1,313 @@
+package clash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/Dreamacro/clash/log"
+	"github.com/robfig/cron/v3"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+// 配置检查的结果
+type CheckResult struct {
+	Errors   []string
+	Warnings []string
+	Summary  *Summary //合并后生效的配置, 合并失败时为空
+}
+
+// 合并后生效的配置摘要
+type Summary struct {
+	Current     string
+	MixedPort   int
+	Port        int
+	SocksPort   int
+	RedirPort   int
+	TProxyPort  int
+	AllowLan    bool
+	Mode        string
+	LogLevel    string
+	Controller  string
+	DNS         string //DNS 监听地址, 未启用时为空
+	DNSMode     string
+	Proxies     int //节点数量
+	Groups      int //策略组数量
+	Providers   int
+	Rules       int
+	Transparent bool
+	Mark        int
+}
+
+func (r *CheckResult) errorf(format string, args ...any) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+func (r *CheckResult) warnf(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// 离线检查数据目录中的配置, 不启动内核
+func Check(homeDir string) (r *CheckResult) {
+	r = &CheckResult{}
+	s := New(homeDir)
+
+	var err error
+	if s.homeDir, err = filepath.Abs(s.homeDir); err != nil {
+		r.errorf("%v", err)
+		return
+	}
+	constant.SetHomeDir(s.pathResolve(CLASH_DIR))
+	log.SetLevel(log.SILENT)
+
+	if !s.checkConfig(r) {
+		return
+	}
+
+	//订阅目录中的所有文件
+	files, _ := filepath.Glob(s.pathResolve(SUBSCRIBE_DIR, "*.yaml"))
+	for _, fn := range files {
+		if _, err := executor.ParseWithPath(fn); err != nil {
+			r.errorf("%s: %v", s.pathRel(fn), err)
+		}
+	}
+
+	for _, fn := range []string{"general.yaml", "dns.yaml"} {
+		if _, err := os.Stat(s.pathResolve(fn)); os.IsNotExist(err) {
+			r.warnf("%s 不存在, 使用订阅中的配置", fn)
+			continue
+		}
+		if _, err := executor.ParseWithPath(s.pathResolve(fn)); err != nil {
+			r.errorf("%s: %v", fn, err)
+		}
+	}
+	for _, it := range s.config.Subscribe {
+		for _, kind := range []string{PRESET_GENERAL, PRESET_DNS} {
+			if !s.hasPreset(it, kind) {
+				continue
+			}
+			if _, err := s.presetConfig(kind, it); err != nil {
+				r.errorf("subscribe [%s]: %v", it.Name, err)
+			}
+		}
+	}
+
+	//与 clashRun 相同的合并
+	fMain := s.pathResolve(SUBSCRIBE_DIR, s.config.Current+".yaml")
+	if s.clash, err = executor.ParseWithPath(fMain); err != nil {
+		if os.IsNotExist(err) {
+			r.warnf("当前订阅 [%s] 还没有下载, 运行时会先更新", s.config.Current)
+		}
+		return
+	}
+	s.loadGeneral(s.config.Current)
+	s.clashOverride(true)
+	top, others := s.groupConflicts(s.curSubscribe)
+	if name, _ := s.groupsConfig(s.curSubscribe).names(); top {
+		r.errorf("groups.name: 当前订阅中已有 [%s], 顶层策略组不会生成, 请换一个名称", name)
+	}
+	if len(others) > 0 {
+		r.warnf("groups: 当前订阅中已有 %v, 这些策略组不会生成", others)
+	}
+	for _, rs := range s.ruleSets() {
+		if payload, _ := s.rulePayload(rs.Name); len(payload) > 0 && rulePolicy(rs.Policy, s.clash.Proxies) == "" {
+			r.warnf("rules [%s]: 当前订阅中没有策略 [%s], 规则集不会生效", rs.Name, rs.Policy)
+		}
+	}
+	r.Summary = s.summary()
+	return
+}
+
+// 检查 config.yaml, 无法加载时返回 false
+func (s *Service) checkConfig(r *CheckResult) (loaded bool) {
+	data, err := readConfig(s.pathResolve(CONFIG_FN))
+	if err != nil {
+		r.errorf("%v", err)
+		return
+	}
+
+	//未知的字段
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	var raw Config
+	if err = dec.Decode(&raw); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for _, it := range typeErr.Errors {
+				r.errorf("%s: %s", CONFIG_FN, it)
+			}
+		} else {
+			r.errorf("%s: %v", CONFIG_FN, err)
+			return
+		}
+	}
+
+	if err = s.load(); err != nil {
+		r.errorf("%s: %v", CONFIG_FN, err)
+		return
+	}
+
+	if len(s.config.Subscribe) == 0 {
+		r.errorf("订阅为空, 请在 %s 中添加订阅", CONFIG_FN)
+		return
+	}
+	loaded = true
+
+	if raw.Current != "" && !lo.ContainsBy(s.config.Subscribe, nameEq(raw.Current)) {
+		r.warnf("current: 订阅 [%s] 不存在, 使用 [%s]", raw.Current, s.config.Current)
+	}
+
+	seen := map[string]bool{}
+	for i, it := range s.config.Subscribe {
+		if it.Name == "" {
+			r.errorf("subscribe[%d]: 名称和链接都为空", i)
+			continue
+		}
+		if key := strings.ToLower(it.Name); seen[key] {
+			r.errorf("subscribe [%s]: 名称重复", it.Name)
+		} else {
+			seen[key] = true
+		}
+		data := s.templateData(it)
+		if _, _, _, err := s.subscribeRender(it, data); err != nil {
+			r.warnf("subscribe [%s]: %v", it.Name, err)
+		}
+		if len(it.Exec) > 0 && it.Git != nil {
+			r.errorf("subscribe [%s]: exec 和 git 不能同时设置", it.Name)
+		}
+		if len(it.Exec) > 0 {
+			if _, err := exec.LookPath(it.Exec[0]); err != nil && !strings.Contains(it.Exec[0], "{{") {
+				r.warnf("subscribe [%s]: exec: %v", it.Name, err)
+			}
+		}
+		if g := it.Git; g != nil {
+			repo := lo.Ternary(filepath.IsAbs(g.Repo), g.Repo, s.pathResolve(g.Repo))
+			if g.Repo == "" || g.Path == "" {
+				r.errorf("subscribe [%s]: git.repo 和 git.path 不能为空", it.Name)
+			} else if _, err := os.Stat(filepath.Join(repo, ".git")); err != nil {
+				r.warnf("subscribe [%s]: git.repo %s 不是 git 仓库", it.Name, g.Repo)
+			}
+		}
+		if it.Auth != nil {
+			if it.Auth.Url == "" {
+				r.errorf("subscribe [%s]: auth.url 为空", it.Name)
+			} else if _, err := s.render(it.Auth.Url, data); err != nil {
+				r.warnf("subscribe [%s]: auth.url: %v", it.Name, err)
+			}
+		}
+		if !it.hasSource() {
+			if _, err := os.Stat(s.pathResolve(SUBSCRIBE_DIR, it.Name+".yaml")); err != nil {
+				r.errorf("subscribe [%s]: 链接为空, 且 %s 不存在", it.Name, filepath.Join(SUBSCRIBE_DIR, it.Name+".yaml"))
+			} else {
+				r.warnf("subscribe [%s]: 链接为空, 只使用本地文件", it.Name)
+			}
+		}
+		if it.Cron != "" {
+			if _, err := cron.ParseStandard(it.Cron); err != nil {
+				r.errorf("subscribe [%s]: 更新计划 %q 错误: %v", it.Name, it.Cron, err)
+			}
+		}
+	}
+
+	for _, e := range s.config.Groups.validate() {
+		r.errorf("%s", e)
+	}
+	for _, it := range s.config.Subscribe {
+		if it.Groups != nil {
+			for _, e := range it.Groups.validate() {
+				r.errorf("subscribe [%s]: %s", it.Name, e)
+			}
+		}
+	}
+
+	for _, rs := range s.ruleSets() {
+		switch rs.Behavior {
+		case "", "domain", "ipcidr", "classical":
+		default:
+			r.errorf("rules [%s]: 不支持的类型 %s", rs.Name, rs.Behavior)
+			continue
+		}
+		if rs.Policy == "" {
+			r.errorf("rules [%s]: policy 为空", rs.Name)
+		}
+		payload, err := s.rulePayload(rs.Name)
+		if err != nil {
+			r.errorf("rules [%s]: %v", rs.Name, err)
+			continue
+		}
+		for _, it := range payload {
+			if _, err := ruleParse(rs, it, "DIRECT"); err != nil {
+				r.warnf("rules [%s]: %s: %v", rs.Name, it, err)
+			}
+		}
+	}
+
+	if s.config.Dashboard != "" {
+		if err := dashboardName(s.config.Dashboard); err != nil {
+			r.errorf("dashboard: %v", err)
+		}
+	}
+	for _, it := range s.config.Dashboards {
+		if err := dashboardName(it.Name); err != nil {
+			r.errorf("dashboards: %v", err)
+		}
+		if it.Url == "" {
+			r.errorf("dashboards [%s]: 链接为空", it.Name)
+		}
+		if it.Cron != "" {
+			if _, err := cron.ParseStandard(it.Cron); err != nil {
+				r.errorf("dashboards [%s]: 更新计划 %q 错误: %v", it.Name, it.Cron, err)
+			}
+		}
+	}
+	return
+}
+
+// 合并后生效的配置摘要
+func (s *Service) summary() *Summary {
+	var (
+		cfg     = s.clash
+		general = cfg.General
+		groups  = lo.CountBy(lo.Values(lo.OmitByKeys(cfg.Proxies, []string{"GLOBAL"})), isGroup)
+	)
+
+	sum := &Summary{
+		Current:     s.config.Current,
+		MixedPort:   general.MixedPort,
+		Port:        general.Port,
+		SocksPort:   general.SocksPort,
+		RedirPort:   general.RedirPort,
+		TProxyPort:  general.TProxyPort,
+		AllowLan:    general.AllowLan,
+		Mode:        general.Mode.String(),
+		LogLevel:    general.LogLevel.String(),
+		Controller:  general.ExternalController,
+		Proxies:     proxyCount(cfg),
+		Groups:      groups,
+		Providers:   lo.CountBy(lo.Values(cfg.Providers), func(it types.ProxyProvider) bool { return it.VehicleType() != types.Compatible }),
+		Rules:       len(cfg.Rules),
+		Transparent: s.config.Transparent.Enable,
+		Mark:        general.RoutingMark,
+	}
+	if cfg.DNS != nil && cfg.DNS.Enable {
+		sum.DNS = cfg.DNS.Listen
+		sum.DNSMode = cfg.DNS.EnhancedMode.String()
+	}
+	return sum
+}
+
+func (s *Service) pathRel(fn string) string {
+	if rel, err := filepath.Rel(s.homeDir, fn); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return fn
+}
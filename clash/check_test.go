@@ -0,0 +1,36 @@
+package clash
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dreamacro/clash/hub/executor"
+)
+
+// 生成的策略组与订阅中的策略组重名时不生成, check 报告
+func TestGroupConflicts(t *testing.T) {
+	tests := []struct {
+		groups Groups
+		top    bool
+		others []string
+	}{
+		{Groups{Enable: true}, false, []string{}},
+		{Groups{Enable: true, Name: "PROXY"}, true, []string{}},
+		{Groups{Enable: true, Auto: "PROXY", Regions: []*Region{{Name: "HK 01", Pattern: "HK"}, {Name: "日本", Pattern: "JP"}}}, false, []string{"PROXY", "HK 01"}},
+	}
+	for _, tt := range tests {
+		s := testService(t)
+		s.config.Groups = tt.groups
+
+		var err error
+		if s.clash, err = executor.ParseWithPath(s.pathResolve(SUBSCRIBE_DIR, "a.yaml")); err != nil {
+			t.Fatal(err)
+		}
+		s.clashOverride(true)
+
+		top, others := s.groupConflicts(s.curSubscribe)
+		if top != tt.top || !reflect.DeepEqual(others, tt.others) {
+			t.Errorf("%+v: groupConflicts() = %v, %q, want %v, %q", tt.groups, top, others, tt.top, tt.others)
+		}
+	}
+}
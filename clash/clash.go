@@ -8,22 +8,21 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	_ "time/tzdata"
 
+	"github.com/Dreamacro/clash/component/dialer"
 	"github.com/Dreamacro/clash/component/mmdb"
 	"github.com/Dreamacro/clash/config"
 	"github.com/Dreamacro/clash/constant"
 	"github.com/Dreamacro/clash/dns"
 	"github.com/Dreamacro/clash/hub/executor"
-	"github.com/Dreamacro/clash/hub/route"
-	"github.com/Dreamacro/clash/log"
 	"github.com/Dreamacro/clash/tunnel"
+	"github.com/hxnas/hlash/pkg/logs"
 	"github.com/robfig/cron/v3"
 	"github.com/samber/lo"
 	"go.uber.org/automaxprocs/maxprocs"
@@ -38,6 +37,8 @@ const (
 	SUBSCRIBE_DIR = "subscribe"
 	CLASH_DIR     = "clash"
 	CONFIG_FN     = "config.yaml"
+
+	DEFAULT_MARK = 0xff //透明代理启用时默认的出站流量标记
 )
 
 type Service struct {
@@ -48,22 +49,69 @@ type Service struct {
 	clash   *config.Config
 	dns     *config.DNS
 	general *config.General
+	ctrl    *controller
+	wg      sync.WaitGroup
+
+	state   State
+	stateMu sync.Mutex
+
+	//mu 保护 config, curSubscribe, clash, dns, general, preferred, subRules, groups 和 scheduleStop
+	//API, 健康检查和计划任务读取时持有读锁; 同时需要 switchMu 时先取 switchMu
+	mu           sync.RWMutex
+	switchMu     sync.Mutex //运行中切换订阅
+	runCtx       context.Context
+	scheduleStop func()
+	preferred    string //配置文件中的当前订阅或手动切换的订阅, 故障转移后恢复时切换回来
+	healthMu     sync.Mutex
+	tokens       sync.Map         //订阅名称 => *cachedToken
+	subRules     []constant.Rule  //订阅中的规则, 不包括本地规则集
+	groups       []map[string]any //生成的策略组, 顺序与 GLOBAL 中相同, config show 输出
+
+	selectedMu sync.Mutex
+
+	logLevel string
 }
 
 // 配置
 type Config struct {
-	Current   string //当前配置名称
-	Subscribe []*Subscribe
+	Current     string        //当前配置名称
+	Mark        int           //出站流量标记(SO_MARK), 内核和下载的连接都会带上, 透明代理规则据此放行
+	Transparent Transparent   //透明代理
+	Controller  Controller    //RESTful API
+	Drain       time.Duration //关闭时等待活动连接结束的时间, 默认5s
+	Log         Log           //hlash 自身的日志
+	Health      Health        //节点健康检查和订阅的故障转移
+	Subscribe   []*Subscribe
+	Rules       []*RuleSet   //本地规则集, 放在订阅的规则前面
+	Groups      Groups       //自动生成策略组
+	Dashboard   string       //当前使用的面板名称, 为空时保持上次的选择
+	Dashboards  []*Dashboard //面板列表
+}
+
+// 透明代理, 仅支持Linux(iptables)
+type Transparent struct {
+	Enable bool     //启用
+	Local  bool     //同时代理本机发出的流量
+	DNS    bool     //劫持局域网的DNS请求(53端口)到内核的DNS服务
+	Bypass []string //不代理的目标网段, 为空时使用保留地址段
 }
 
 // 订阅
 type Subscribe struct {
-	Name    string   //显示名称
-	Url     string   //更新链接
-	Method  string   //更新时使用的HTTP方法
-	Headers []string //更新时使用的HTTP请求头
-	Body    string   //更新请求的Body参数
-	Cron    string   //更新计划
+	Name    string         //显示名称
+	Url     string         //更新链接, 可以引用 ${env:NAME} 或 ${file:path}, 支持模板如 {{.Hostname}}
+	UrlFile string         `yaml:"url-file"` //从文件读取更新链接, 相对于数据目录, 优先于 url
+	Method  string         //更新时使用的HTTP方法
+	Headers []string       //更新时使用的HTTP请求头
+	Body    string         //更新请求的Body参数
+	Cron    string         //更新计划
+	Auth    *SubscribeAuth //更新前先请求令牌
+	Exec    []string       //执行命令, 使用标准输出作为订阅文件, 优先于 url
+	Git     *SubscribeGit  //从本地 git 仓库读取订阅文件, 优先于 url
+	Timeout time.Duration  //exec 的超时时间, 默认1m
+	General map[string]any //叠加在 general.yaml 上的预设, 也可以放在 subscribe/<name>.general.yaml
+	DNS     map[string]any `yaml:"dns"` //叠加在 dns.yaml 的 dns 上的预设, 也可以放在 subscribe/<name>.dns.yaml
+	Groups  *Groups        //自动生成策略组, 覆盖全局的 groups
 
 	updated  time.Time
 	schedule cron.Schedule
@@ -74,6 +122,16 @@ func New(homeDir string) *Service {
 	return &Service{homeDir: homeDir}
 }
 
+// 加载配置, 不启动内核
+func (s *Service) Load() (err error) {
+	if s.homeDir, err = filepath.Abs(s.homeDir); err != nil {
+		return
+	}
+
+	constant.SetHomeDir(s.pathResolve(CLASH_DIR))
+	return s.load()
+}
+
 // 开始运行
 func (s *Service) Run(ctx context.Context) (err error) {
 	if s.homeDir, err = filepath.Abs(s.homeDir); err != nil {
@@ -82,36 +140,86 @@ func (s *Service) Run(ctx context.Context) (err error) {
 
 	constant.SetHomeDir(s.pathResolve(CLASH_DIR))
 
+	//首次运行, 初始化数据目录
+	if _, e := os.Stat(s.pathResolve(CONFIG_FN)); os.IsNotExist(e) {
+		created, e := Init(s.homeDir, InitOptions{AllowLan: true})
+		if e != nil {
+			err = fmt.Errorf("初始化失败: %w", e)
+			return
+		}
+		for _, fn := range created {
+			logs.Infof("[初始化] 写入 %s", fn)
+		}
+	}
+
 	if err = s.load(); err != nil {
 		return
 	}
 
-	s.loadGeneral()
+	//出错提前返回时也要让计划任务和下载退出, 否则关闭时一直等待
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if err = s.logSetup(); err != nil {
+		return
+	}
+	defer logs.Close()
+	logCore(ctx.Done())
+
+	s.loadGeneral(s.config.Current)
+
+	//下载和内核的出站连接都使用同一个标记
+	dialer.DefaultRoutingMark.Store(int32(s.mark()))
+
+	s.dashboardPrepare(ctx)
 
 	if err = s.clashStart(ctx); err != nil {
 		return
 	}
 
-	s.subscribeRun(ctx)
+	s.mu.Lock()
+	s.preferred = s.config.Current
+	s.mu.Unlock()
+	s.stateStart()
+	defer s.stateStop()
+	defer s.shutdown()
+	defer cancel()
+
+	if s.config.Transparent.Enable {
+		if err = s.forward(ctx); err != nil {
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.scheduleStart(ctx)
+	s.mu.Unlock()
 
 	<-ctx.Done()
 	return
 }
 
+// 读取 config.yaml, 替换当前的配置; 运行中时按新的配置重新开始计划任务
 func (s *Service) load() (err error) {
-	configFn := s.pathResolve(CONFIG_FN)
-	if err = readYaml(configFn, &s.config); err != nil {
+	data, err := readConfig(s.pathResolve(CONFIG_FN))
+	if err != nil {
+		return
+	}
+	var cfg Config
+	if err = yaml.Unmarshal(data, &cfg); err != nil {
 		return
 	}
 
 	//格式化
-	lo.ForEach(s.config.Subscribe, func(subscribe *Subscribe, _ int) {
+	for i, subscribe := range cfg.Subscribe {
+		if subscribe.Name == "" && subscribeNeedsName(subscribe.Url) {
+			return fmt.Errorf("subscribe[%d]: 链接中引用了环境变量, 文件或模板, 请指定名称", i)
+		}
+	}
+	lo.ForEach(cfg.Subscribe, func(subscribe *Subscribe, _ int) {
 		if subscribe.Name == "" && subscribe.Url != "" {
-			u := subscribe.Url
-			if strings.Contains(u, "?") {
-				u = strings.Split(subscribe.Url, "?")[0]
-			}
-			subscribe.Name = filepath.Base(u)
+			subscribe.Name = subscribeDefaultName(subscribe.Url)
 		}
 
 		if stat, _ := os.Stat(s.pathResolve(SUBSCRIBE_DIR, subscribe.Name+".yaml")); stat != nil {
@@ -120,13 +228,36 @@ func (s *Service) load() (err error) {
 	})
 
 	//获取默认的配置
-	if len(s.config.Subscribe) > 0 {
-		if s.curSubscribe, _ = lo.Find(s.config.Subscribe, nameEq(s.config.Current)); s.curSubscribe == nil {
-			s.curSubscribe = s.config.Subscribe[0]
-			s.config.Current = s.curSubscribe.Name
+	var cur *Subscribe
+	if len(cfg.Subscribe) > 0 {
+		if cur, _ = lo.Find(cfg.Subscribe, nameEq(cfg.Current)); cur == nil {
+			cur = cfg.Subscribe[0]
+			cfg.Current = cur.Name
 		}
 	}
 
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.runCtx == nil {
+		s.config, s.curSubscribe = cfg, cur
+		return
+	}
+
+	//运行中: 先停止计划, 更新计划未修改的任务保留下次执行的时间
+	s.scheduleStop()
+	for _, it := range cfg.Subscribe {
+		if old, _ := lo.Find(s.config.Subscribe, nameEq(it.Name)); old != nil && old.Cron == it.Cron {
+			it.next = old.next
+		}
+	}
+	for _, it := range cfg.Dashboards {
+		if old, _ := lo.Find(s.config.Dashboards, func(d *Dashboard) bool { return d.Name == it.Name }); old != nil && old.Cron == it.Cron {
+			it.next = old.next
+		}
+	}
+	s.config, s.curSubscribe = cfg, cur
+	s.scheduleStart(s.runCtx)
 	return
 }
 
@@ -141,12 +272,20 @@ func (s *Service) clashStart(ctx context.Context) (err error) {
 	return
 }
 
-func (s *Service) loadGeneral() {
-	if c, _ := executor.ParseWithPath(s.pathResolve("dns.yaml")); c != nil && c.DNS != nil {
+// 加载 general.yaml 和 dns.yaml, 订阅有自己的预设时叠加在上面
+func (s *Service) loadGeneral(name string) {
+	sub, _ := lo.Find(s.config.Subscribe, nameEq(name))
+	s.dns, s.general = nil, nil
+
+	if c, err := s.presetConfig(PRESET_DNS, sub); err != nil {
+		logs.Warnf("[预设] [%s] %v", name, err)
+	} else if c != nil && c.DNS != nil {
 		s.dns = c.DNS
 	}
 
-	if c, _ := executor.ParseWithPath(s.pathResolve("general.yaml")); c != nil && c.General != nil {
+	if c, err := s.presetConfig(PRESET_GENERAL, sub); err != nil {
+		logs.Warnf("[预设] [%s] %v", name, err)
+	} else if c != nil && c.General != nil {
 		s.general = c.General
 	}
 }
@@ -160,7 +299,7 @@ func (s *Service) loadSubscribe(ctx context.Context) (err error) {
 	}
 
 	if name == "" {
-		err = fmt.Errorf("订阅为空")
+		err = fmt.Errorf("订阅为空, 请在 %s 中添加订阅", s.pathResolve(CONFIG_FN))
 		return
 	}
 
@@ -174,7 +313,7 @@ func (s *Service) loadSubscribe(ctx context.Context) (err error) {
 
 		sub, _ := lo.Find(s.config.Subscribe, nameEq(s.config.Current))
 		if sub == nil {
-			log.Infoln("[订阅] [%s] 不存在", s.config.Current)
+			logs.Warnf("[订阅] [%s] 不存在", s.config.Current)
 			return
 		}
 
@@ -184,6 +323,7 @@ func (s *Service) loadSubscribe(ctx context.Context) (err error) {
 		}
 	}
 
+	s.providerPrefetch(ctx, fMain)
 	if s.clash, err = executor.ParseWithPath(fMain); err != nil && !os.IsNotExist(err) {
 		return
 	}
@@ -197,6 +337,24 @@ func (s *Service) clashRun() (err error) {
 		return
 	}
 
+	s.clashOverride(false)
+
+	if err = s.controllerStart(); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	executor.ApplyConfig(s.clash, true)
+	s.selectedRestore(s.config.Current)
+	s.mu.Unlock()
+	return
+}
+
+// 使用 dns.yaml, general.yaml 和出站流量标记覆盖订阅中的配置, 加入生成的策略组和本地规则集
+// offline 为真时只用于检查和输出, 生成的策略组不测试节点
+func (s *Service) clashOverride(offline bool) {
+	s.groupGenerate(s.curSubscribe, offline)
+
 	if s.dns != nil {
 		s.clash.DNS = s.dns
 	}
@@ -205,123 +363,177 @@ func (s *Service) clashRun() (err error) {
 		s.clash.General = s.general
 	}
 
-	if s.clash.General.ExternalUI != "" {
-		route.SetUIPath(s.clash.General.ExternalUI)
+	if mark := s.mark(); mark != 0 {
+		s.clash.General.RoutingMark = mark
 	}
 
-	if s.clash.General.ExternalController != "" {
-		go route.Start(s.clash.General.ExternalController, s.clash.General.Secret)
+	//内核在 cache.db 中按策略组名称记录选择, 不区分订阅, 切换后会套用其他订阅中同名策略组的选择
+	//由 selected.json 按订阅记录和恢复
+	if s.clash.Profile != nil {
+		s.clash.Profile.StoreSelected = false
 	}
 
-	executor.ApplyConfig(s.clash, true)
-	return
+	//本地规则集放在订阅的规则前面
+	s.subRules = s.clash.Rules
+	s.clash.Rules = append(s.localRules(s.clash.Proxies), s.subRules...)
+
+	if s.config.Transparent.Enable && s.config.Transparent.DNS {
+		s.prepareDNS()
+	}
 }
 
-// 按计划更新
-func (s *Service) subscribeRun(ctx context.Context) {
-	go func() {
-		list := lo.Filter(s.config.Subscribe, func(it *Subscribe, _ int) bool { return it.Url != "" && it.Cron != "" })
+// 运行中切换到指定的订阅, 不重建入站监听
+func (s *Service) subscribeSwitch(name string) (err error) {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
 
-		lo.ForEach[*Subscribe](list, func(subscribe *Subscribe, index int) {
-			if subscribe.schedule == nil && subscribe.Cron != "" {
-				subscribe.schedule, _ = cron.ParseStandard(subscribe.Cron)
-			}
-			if subscribe.next.IsZero() && subscribe.schedule != nil {
-				subscribe.next = subscribe.schedule.Next(time.Now())
-			}
-		})
+	return s.subscribeApply(name, false)
+}
 
-		for {
-			list = lo.Filter(list, func(it *Subscribe, _ int) bool { return it.Url != "" && it.Cron != "" && !it.next.IsZero() })
+// 订阅的更新计划
+func (s *Service) subscribeTasks() (tasks []*task) {
+	list := lo.Filter(s.config.Subscribe, func(it *Subscribe, _ int) bool { return it.hasSource() && it.Cron != "" })
 
-			nearly := lo.MinBy(list, func(a, b *Subscribe) bool { return a.next.Before(b.next) })
-			sleep := -time.Since(nearly.next)
-			if sleep < 0 {
-				sleep = time.Millisecond
-			}
-			log.Infoln("[订阅] 最近需要更新: %s, 等待 %s", nearly.Name, sleep)
-
-			select {
-			case <-ctx.Done():
-				log.Infoln("[订阅] 退出更新")
-				return
-			case <-time.After(sleep):
-				if nearly.next = nearly.schedule.Next(time.Now()); nearly.next.Before(time.Now()) {
-					nearly.next = time.Time{}
-				}
-				log.Infoln("[订阅] [%s] 更新", nearly.Name)
-				go s.subscribeUpdate(ctx, nearly)
-			}
+	for _, subscribe := range list {
+		subscribe := subscribe
+		if subscribe.schedule == nil {
+			subscribe.schedule, _ = cron.ParseStandard(subscribe.Cron)
 		}
-	}()
+		tasks = append(tasks, &task{
+			name:     "订阅/" + subscribe.Name,
+			schedule: subscribe.schedule,
+			next:     &subscribe.next,
+			run:      func(ctx context.Context) { s.subscribeUpdate(ctx, subscribe) },
+		})
+	}
+	return
 }
 
 // 指定链接和名称更新
 func (s *Service) subscribeUpdate(ctx context.Context, subscribe *Subscribe) (success bool) {
-	if subscribe.Url == "" {
-		log.Infoln("[订阅] [%s] 链接为空", subscribe.Name)
-		return
-	}
-
 	var (
 		target = s.pathResolve(SUBSCRIBE_DIR, subscribe.Name+".yaml")
 		tempDl = target + ".update"
-		backup = target + "-" + time.Now().Format("20060102-150405") + ".backup"
+		backup = target + "-" + time.Now().Format("20060102-150405.000000000") + ".backup"
 
 		hasBackup bool
+		header    http.Header
+		cfg       *config.Config
+		st        = &SubscribeState{}
 		err       error
 	)
 
-	log.Infoln("[订阅] [%s] 下载... %s", subscribe.Name, subscribe.Url)
-	if err = download(ctx, subscribe.Method, subscribe.Url, subscribe.Headers, subscribe.Body, tempDl); err != nil {
-		log.Infoln("[订阅] 下载失败: %v", err)
+	defer func() {
+		if !success && err == nil {
+			err = fmt.Errorf("更新失败")
+		}
+		s.stateSubscribe(subscribe.Name, st, err)
+	}()
+
+	if !subscribe.hasSource() {
+		logs.Errorf("[订阅] [%s] 链接为空", subscribe.Name)
+		err = fmt.Errorf("链接为空")
 		return
 	}
 
-	log.Infoln("[订阅] [%s] 检查... %s", subscribe.Name, tempDl)
-	if _, err = executor.ParseWithPath(tempDl); err != nil {
-		log.Infoln("[订阅] [%s] 检查失败: %v", subscribe.Name, err)
+	logs.Infof("[订阅] [%s] 下载... %s", subscribe.Name, subscribe.source())
+	start := time.Now()
+	header, err = s.fetch(ctx, subscribe, tempDl)
+	st.Duration = time.Since(start)
+	if err != nil {
+		logs.Errorf("[订阅] [%s] 下载失败: %v", subscribe.Name, err)
+		//令牌可能已失效, 下次重新请求
+		s.tokens.Delete(subscribe.Name)
 		return
 	}
 
+	s.providerPrefetch(ctx, tempDl)
+	logs.Infof("[订阅] [%s] 检查... %s", subscribe.Name, tempDl)
+	if cfg, err = executor.ParseWithPath(tempDl); err != nil {
+		logs.Errorf("[订阅] [%s] 检查失败: %v", subscribe.Name, err)
+		return
+	}
+	st.Proxies = proxyCount(cfg)
+	st.Userinfo = parseUserinfo(header.Get("Subscription-Userinfo"))
+
 	//备份之前的文件
 	if stat, _ := os.Stat(target); stat != nil {
-		log.Infoln("[订阅] [%s] 备份... %s => %s", subscribe.Name, filepath.Base(target), filepath.Base(backup))
+		logs.Infof("[订阅] [%s] 备份... %s => %s", subscribe.Name, filepath.Base(target), filepath.Base(backup))
 		if err = os.Rename(target, backup); err != nil {
-			log.Infoln("[订阅] [%s] 备份失败: %v", subscribe.Name, err)
+			logs.Errorf("[订阅] [%s] 备份失败: %v", subscribe.Name, err)
 			return
 		}
 		hasBackup = true
 	}
 
-	log.Infoln("[订阅] [%s] 写入...", subscribe.Name)
+	logs.Infof("[订阅] [%s] 写入...", subscribe.Name)
 	if err = os.Rename(tempDl, target); err != nil {
-		log.Infoln("[订阅] [%s] 写入失败: %v", subscribe.Name, err)
+		logs.Errorf("[订阅] [%s] 写入失败: %v", subscribe.Name, err)
 		//回滚
 		if hasBackup {
-			log.Infoln("[订阅] [%s] 回滚... %s", subscribe.Name, backup)
+			logs.Warnf("[订阅] [%s] 回滚... %s", subscribe.Name, backup)
 			if err = os.Rename(backup, target); err != nil {
-				log.Infoln("[订阅] [%s] 回滚失败: %v", subscribe.Name, err)
+				logs.Errorf("[订阅] [%s] 回滚失败: %v", subscribe.Name, err)
 			}
 		}
 		return
 	}
 
-	log.Infoln("[订阅] [%s] 更新完成", subscribe.Name)
+	logs.Infof("[订阅] [%s] 更新完成", subscribe.Name)
 	return true
 }
 
+// 当前订阅名称
+func (s *Service) Current() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Current
+}
+
+// 按名称查找订阅, 不存在时返回 nil
+func (s *Service) subscribeFind(name string) (sub *Subscribe) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, _ = lo.Find(s.config.Subscribe, nameEq(name))
+	return
+}
+
+// 出站流量标记, 未配置时如果启用了透明代理则使用默认值
+func (s *Service) mark() int {
+	if s.config.Mark != 0 {
+		return s.config.Mark
+	}
+	if s.config.Transparent.Enable {
+		return DEFAULT_MARK
+	}
+	return 0
+}
+
 func (s *Service) pathResolve(names ...string) string {
 	return filepath.Join(append([]string{s.homeDir}, names...)...)
 }
 
+// 链接中引用了环境变量, 文件或模板时文件名不是最终的, 需要指定名称
+func subscribeNeedsName(u string) bool {
+	return strings.Contains(u, "${") || strings.Contains(u, "{{")
+}
+
+// 未设置名称时使用链接的文件名
+func subscribeDefaultName(u string) string {
+	if strings.Contains(u, "?") {
+		u = strings.Split(u, "?")[0]
+	}
+	return filepath.Base(u)
+}
+
 func nameEq(name string) func(*Subscribe) bool {
 	return func(it *Subscribe) bool { return strings.EqualFold(it.Name, name) }
 }
 
 func initMMDB() (err error) {
 	downloadMMDB := func(path string) (err error) {
-		resp, err := http.Get("https://cdn.jsdelivr.net/gh/Dreamacro/maxmind-geoip@release/Country.mmdb")
+		client := &http.Client{Transport: newTransport()}
+		resp, err := client.Get("https://cdn.jsdelivr.net/gh/Dreamacro/maxmind-geoip@release/Country.mmdb")
 		if err != nil {
 			return
 		}
@@ -338,7 +550,7 @@ func initMMDB() (err error) {
 	}
 
 	if _, err = os.Stat(constant.Path.MMDB()); os.IsNotExist(err) {
-		log.Infoln("Can't find MMDB, start download")
+		logs.Infof("[MMDB] 不存在, 开始下载")
 		if err = downloadMMDB(constant.Path.MMDB()); err != nil {
 			err = fmt.Errorf("can't download MMDB: %s", err.Error())
 			return
@@ -346,7 +558,7 @@ func initMMDB() (err error) {
 	}
 
 	if !mmdb.Verify() {
-		log.Warnln("MMDB invalid, remove and download")
+		logs.Warnf("[MMDB] 无效, 删除后重新下载")
 		if err := os.Remove(constant.Path.MMDB()); err != nil {
 			return fmt.Errorf("can't remove invalid MMDB: %s", err.Error())
 		}
@@ -359,23 +571,16 @@ func initMMDB() (err error) {
 	return nil
 }
 
-func download(ctx context.Context, method, url string, headers []string, data string, saveTo string) (err error) {
-	transport := &http.Transport{
-		Proxy:                 http.ProxyFromEnvironment,
-		DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
-		ForceAttemptHTTP2:     true,
-		MaxIdleConns:          100,
-		IdleConnTimeout:       90 * time.Second,
-		TLSHandshakeTimeout:   5 * time.Second,
-		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-	}
-
+func download(ctx context.Context, method, url string, headers []string, data string, saveTo string) (header http.Header, err error) {
 	client := &http.Client{
 		Timeout:   time.Second * 10,
-		Transport: transport,
+		Transport: newTransport(),
 	}
+	return downloadWith(ctx, client, method, url, headers, data, saveTo)
+}
 
+// 使用指定的 client 下载, 失败时重试
+func downloadWith(ctx context.Context, client *http.Client, method, url string, headers []string, data string, saveTo string) (header http.Header, err error) {
 	windowsEdge := func(header http.Header) {
 		header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36 Edg/117.0.2045.31")
 		header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
@@ -416,7 +621,7 @@ func download(ctx context.Context, method, url string, headers []string, data st
 	for i := 0; i < 10; i++ {
 		if i > 0 {
 			sleep := min(time.Second*1<<i, time.Second*15)
-			log.Infoln("[下载] 第%d次重试, 等待时间: %s", i, sleep)
+			logs.Infof("[下载] 第%d次重试, 等待时间: %s", i, sleep)
 			select {
 			case <-ctx.Done():
 				err = ctx.Err()
@@ -425,8 +630,9 @@ func download(ctx context.Context, method, url string, headers []string, data st
 			}
 		}
 		if resp, err = client.Do(req); err != nil {
+			err = redactError(err)
 			if i < 9 {
-				log.Infoln("[下载] 第%d次失败: %v", i, err)
+				logs.Warnf("[下载] 第%d次失败: %v", i, err)
 				continue
 			}
 			return
@@ -436,7 +642,7 @@ func download(ctx context.Context, method, url string, headers []string, data st
 		if resp.StatusCode != 200 {
 			err = fmt.Errorf(resp.Status)
 			if resp.StatusCode > 404 && i < 9 {
-				log.Infoln("[下载] 第%d次失败: %s", i, resp.Status)
+				logs.Warnf("[下载] 第%d次失败: %s", i, resp.Status)
 				continue
 			}
 			return
@@ -445,11 +651,31 @@ func download(ctx context.Context, method, url string, headers []string, data st
 		if err = readToFile(resp.Body, saveTo, true); err != nil {
 			continue
 		}
+		header = resp.Header
 		break
 	}
 	return
 }
 
+// 下载使用的Transport, 带上出站流量标记以免被透明代理规则再次转发
+func newTransport() *http.Transport {
+	d := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	if mark := dialer.DefaultRoutingMark.Load(); mark != 0 {
+		d.Control = markControl(int(mark))
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+	}
+}
+
 func readToFile(src io.Reader, dstFilename string, overwrite bool) (err error) {
 	if err = os.MkdirAll(filepath.Dir(dstFilename), 0755); err != nil {
 		return
@@ -480,35 +706,6 @@ func readYaml(fn string, value any) (err error) {
 	return yaml.Unmarshal(data, value)
 }
 
-// func (s *Service) watch(ctx context.Context) {
-// 	cDone := make(chan struct{})
-// 	cReload := make(chan struct{}, 1)
-// 	go func() {
-// 		defer close(cDone)
-// 		defer close(cReload)
-//
-// 		for {
-// 			select {
-// 			case <-ctx.Done():
-// 				log.Infoln("[内核] 已退出")
-// 				s.cReload = nil
-// 				return
-// 			case <-cReload:
-// 				if cfg, e := executor.Parse(); e == nil {
-// 					log.Infoln("[内核] 重载配置")
-// 					executor.ApplyConfig(cfg, true)
-// 				} else {
-// 					log.Infoln("[内核] 新配置检查不通过: %v", e)
-// 				}
-// 			}
-// 		}
-//
-// 	}()
-//
-// 	// s.done = cDone
-// 	// s.cReload = cReload
-// }
-
 func DNSDefault() func(cfg *config.Config) {
 	return func(cfg *config.Config) {
 		cfg.General.RedirPort = 7892
@@ -542,37 +739,3 @@ func GeneralDefault() func(cfg *config.Config) {
 		cfg.General.Mode = tunnel.Rule
 	}
 }
-
-func Backward(ctx context.Context) (err error) {
-	if runtime.GOOS == "linux" {
-		sh := `
-iptables -t nat -D PREROUTING -p tcp -j CLASH
-iptables -t nat -F CLASH
-iptables -t nat -X CLASH
-iptables -t nat -F CLASH
-	`
-		err=exec.CommandContext(ctx, "bash", "-c", sh).Run()
-	}
-	return
-}
-
-func Forward(ctx context.Context, redirPort int) (err error) {
-	if runtime.GOOS == "linux" {
-		sh := fmt.Sprintf(`
-iptables -t nat -N clash
-iptables -t nat -A clash -d 0.0.0.0/8 -j RETURN
-iptables -t nat -A clash -d 10.0.0.0/8 -j RETURN
-iptables -t nat -A CLASH -d 169.254.0.0/16 -j RETURN
-iptables -t nat -A clash -d 127.0.0.0/8 -j RETURN
-iptables -t nat -A clash -d 172.16.0.0/12 -j RETURN
-iptables -t nat -A clash -d 192.168.0.0/16 -j RETURN
-iptables -t nat -A CLASH -d 224.0.0.0/4 -j RETURN
-iptables -t nat -A CLASH -d 240.0.0.0/4 -j RETURN
-iptables -t nat -A clash -p tcp -j RETURN -m mark --mark 0xff
-iptables -t nat -A clash -p tcp -j REDIRECT --to-ports %d
-iptables -t nat -A PREROUTING -p tcp -j clash
-	`, redirPort)
-		err=exec.CommandContext(ctx, "bash", "-c", sh).Run()
-	}
-	return
-}
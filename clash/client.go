@@ -0,0 +1,105 @@
+package clash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 访问运行中实例的 RESTful API, 优先使用 Unix socket, 否则使用 general.yaml 中的地址和口令
+type Client struct {
+	base   string
+	secret string
+	client *http.Client
+}
+
+// 根据数据目录创建客户端
+func NewClient(homeDir string) (c *Client, err error) {
+	var cfg Config
+	readYaml(filepath.Join(homeDir, CONFIG_FN), &cfg)
+
+	c = &Client{client: &http.Client{Timeout: 10 * time.Second}}
+
+	if socket := cfg.Controller.Socket; socket != "" {
+		if !filepath.IsAbs(socket) {
+			socket = filepath.Join(homeDir, socket)
+		}
+		if _, e := os.Stat(socket); e == nil {
+			c.base = "http://hlash"
+			c.client.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			}}
+			return
+		}
+	}
+
+	var general struct {
+		ExternalController string `yaml:"external-controller"`
+		Secret             string `yaml:"secret"`
+	}
+	readYaml(filepath.Join(homeDir, "general.yaml"), &general)
+	if general.ExternalController == "" {
+		err = fmt.Errorf("没有可用的 RESTful API: %s 中未设置 external-controller", filepath.Join(homeDir, "general.yaml"))
+		return
+	}
+
+	host, port, e := net.SplitHostPort(general.ExternalController)
+	if e != nil {
+		err = fmt.Errorf("external-controller 无效: %s", general.ExternalController)
+		return
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	c.base = "http://" + net.JoinHostPort(host, port)
+	c.secret = general.Secret
+	return
+}
+
+// 设置请求的超时时间, 为0时不超时
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.client.Timeout = timeout
+}
+
+// 发送请求, 返回响应内容, 状态码不是2xx时返回错误
+func (c *Client) Do(ctx context.Context, method, path string, body io.Reader) (data []byte, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, body)
+	if err != nil {
+		return
+	}
+	if c.secret != "" {
+		req.Header.Set("Authorization", "Bearer "+c.secret)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if data, err = io.ReadAll(resp.Body); err != nil {
+		return
+	}
+	if resp.StatusCode/100 != 2 {
+		//内核和 hlash 的接口出错时都返回 {"message": "..."}
+		var msg struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &msg) == nil && msg.Message != "" {
+			err = fmt.Errorf("%s: %s", resp.Status, msg.Message)
+		} else {
+			err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+		}
+	}
+	return
+}
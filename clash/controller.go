@@ -0,0 +1,195 @@
+package clash
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/hxnas/hlash/pkg/logs"
+)
+
+// RESTful API
+type Controller struct {
+	Socket  string //Unix socket 路径, 相对于数据目录, 本地工具通过它访问时不需要口令
+	Strict  bool   //监听在非回环地址且没有设置口令时拒绝启动, 否则只警告
+	Metrics string //单独提供 /metrics 的监听地址, 不需要口令; 为空时只在 RESTful API 上提供
+}
+
+// RESTful API 由 hlash 监听和验证口令, 内核的路由在同一个服务中处理, 不单独监听
+type controller struct {
+	secret  string
+	socket  string
+	router  chi.Router
+	servers []*http.Server
+}
+
+// 启动 RESTful API, 监听失败时返回错误
+func (s *Service) controllerStart() (err error) {
+	var (
+		addr   = s.clash.General.ExternalController
+		secret = s.clash.General.Secret
+		socket = s.config.Controller.Socket
+		metric = s.config.Controller.Metrics
+	)
+
+	if addr == "" && socket == "" && metric == "" {
+		return
+	}
+
+	if addr != "" && secret == "" && !isLoopback(addr) {
+		if s.config.Controller.Strict {
+			return fmt.Errorf("[API] %s 不是回环地址, 必须设置口令(secret)", addr)
+		}
+		logs.Warnf("[API] %s 不是回环地址且没有设置口令(secret), 任何人都可以控制内核", addr)
+	}
+
+	var listeners []net.Listener
+	defer func() {
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+		}
+	}()
+
+	if addr != "" {
+		var l net.Listener
+		if l, err = net.Listen("tcp", addr); err != nil {
+			return fmt.Errorf("[API] 监听 %s 失败: %w", addr, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	if socket != "" {
+		if !filepath.IsAbs(socket) {
+			socket = s.pathResolve(socket)
+		}
+		os.Remove(socket)
+
+		var l net.Listener
+		if l, err = listenUnix(socket); err != nil {
+			return fmt.Errorf("[API] 监听 %s 失败: %w", socket, err)
+		}
+		listeners = append(listeners, l)
+	}
+
+	var metricListener net.Listener
+	if metric != "" {
+		if metricListener, err = net.Listen("tcp", metric); err != nil {
+			return fmt.Errorf("[API] 监听 %s 失败: %w", metric, err)
+		}
+		defer func() {
+			if err != nil {
+				metricListener.Close()
+			}
+		}()
+	}
+
+	ctrl := &controller{secret: secret, socket: socket, router: chi.NewRouter()}
+	core := coreRouter()
+	ctrl.router.With(ctrl.authorize).Get("/metrics", s.metricsHandler)
+	ctrl.router.Route("/hlash", func(r chi.Router) {
+		r.Use(ctrl.authorize)
+		s.apiRoutes(r)
+	})
+	//面板的静态文件不需要口令, 与内核相同
+	ui := s.dashboardHandler()
+	if dir := s.clash.General.ExternalUI; dir != "" {
+		ui = http.StripPrefix("/ui", http.FileServer(http.Dir(dir)))
+	}
+	ctrl.router.Get("/ui", http.RedirectHandler("/ui/", http.StatusTemporaryRedirect).ServeHTTP)
+	ctrl.router.Get("/ui/*", ui.ServeHTTP)
+	ctrl.router.With(ctrl.authorize).Put("/proxies/{name}", s.selectedHandler(core))
+	ctrl.router.With(ctrl.authorize).Handle("/*", core)
+
+	for _, l := range listeners {
+		var handler http.Handler = ctrl.router
+		if l.Addr().Network() == "unix" {
+			handler = ctrl.withSecret(handler)
+		}
+		ctrl.serve(l, handler)
+	}
+
+	if metricListener != nil {
+		router := chi.NewRouter()
+		router.Get("/metrics", s.metricsHandler)
+		ctrl.serve(metricListener, router)
+	}
+
+	s.ctrl = ctrl
+	return
+}
+
+func (c *controller) serve(l net.Listener, handler http.Handler) {
+	srv := &http.Server{Handler: handler}
+	c.servers = append(c.servers, srv)
+
+	logs.Infof("[API] 监听 %s", l.Addr())
+	go func() {
+		if e := srv.Serve(l); e != nil && !errors.Is(e, http.ErrServerClosed) {
+			logs.Errorf("[API] %s 服务出错: %v", l.Addr(), e)
+		}
+	}()
+}
+
+// 关闭全部监听, 超时后强制关闭
+func (c *controller) shutdown(ctx context.Context) {
+	for _, srv := range c.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+	if c.socket != "" {
+		os.Remove(c.socket)
+	}
+}
+
+// 验证口令, 与内核相同: 请求头 Authorization: Bearer <secret>, websocket 可以使用 ?token=<secret>
+// CORS 预检请求不带口令, 直接放行
+func (c *controller) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.secret == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if websocket := strings.EqualFold(r.Header.Get("Upgrade"), "websocket"); websocket && r.URL.Query().Get("token") != "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(c.secret)) != 1 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// 通过 Unix socket 访问时自动带上口令
+func (c *controller) withSecret(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.secret != "" && r.Header.Get("Authorization") == "" {
+			r.Header.Set("Authorization", "Bearer "+c.secret)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
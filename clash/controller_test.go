@@ -0,0 +1,82 @@
+package clash
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// 内核的路由在 hlash 的服务中处理, 关闭后释放端口, 同一进程中可以重新启动
+func TestControllerRestart(t *testing.T) {
+	s := testService(t)
+	if err := s.subscribeSwitch("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := freeAddr(t)
+	s.clash.General.ExternalController = addr
+	s.clash.General.Secret = "secret"
+	s.config.Controller.Socket = "hlash.sock"
+	socket := filepath.Join(s.homeDir, "hlash.sock")
+
+	get := func(client *http.Client, url, secret string) int {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	unix := &http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+	}}}
+
+	for i := 0; i < 2; i++ {
+		if err := s.controllerStart(); err != nil {
+			t.Fatalf("第 %d 次启动: %v", i+1, err)
+		}
+		if stat, err := os.Stat(socket); err != nil {
+			t.Fatal(err)
+		} else if runtime.GOOS != "windows" && stat.Mode().Perm() != 0600 {
+			t.Errorf("socket 权限: %v", stat.Mode().Perm())
+		}
+		for _, tt := range []struct {
+			client *http.Client
+			url    string
+			secret string
+			code   int
+		}{
+			{http.DefaultClient, "http://" + addr + "/version", "secret", http.StatusOK},
+			{http.DefaultClient, "http://" + addr + "/proxies/PROXY", "secret", http.StatusOK},
+			{http.DefaultClient, "http://" + addr + "/version", "", http.StatusUnauthorized},
+			{http.DefaultClient, "http://" + addr + "/version", "wrong", http.StatusUnauthorized},
+			{unix, "http://hlash/version", "", http.StatusOK},
+		} {
+			if code := get(tt.client, tt.url, tt.secret); code != tt.code {
+				t.Errorf("%s (%q): %d, want %d", tt.url, tt.secret, code, tt.code)
+			}
+		}
+		s.ctrl.shutdown(context.Background())
+		unix.CloseIdleConnections()
+		if _, err := os.Stat(socket); !os.IsNotExist(err) {
+			t.Errorf("关闭后 socket 应该删除: %v", err)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package clash
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/robfig/cron/v3"
+	"github.com/samber/lo"
+)
+
+const (
+	DASHBOARD_DIR     = "dashboards"
+	DASHBOARD_CURRENT = "current" //记录当前面板名称的文件, external-ui 未设置时在 /ui 提供该面板
+
+	DEFAULT_DASHBOARD_TIMEOUT = 5 * time.Minute //下载发布包的总时间, 包括重试
+)
+
+// 面板(external-ui)
+type Dashboard struct {
+	Name   string //名称, 安装到 dashboards/<name>
+	Url    string //发布包的下载链接, 支持 zip 和 tar.gz
+	Sha256 string //发布包的SHA256, 为空时不校验
+	Cron   string //更新计划
+
+	schedule cron.Schedule
+	next     time.Time
+}
+
+// 已安装的面板
+type DashboardInfo struct {
+	Name    string
+	Current bool
+	Updated time.Time
+}
+
+// 面板的更新计划
+func (s *Service) dashboardTasks() (tasks []*task) {
+	list := lo.Filter(s.config.Dashboards, func(it *Dashboard, _ int) bool { return it.Url != "" && it.Cron != "" })
+
+	for _, dashboard := range list {
+		dashboard := dashboard
+		if dashboard.schedule == nil {
+			dashboard.schedule, _ = cron.ParseStandard(dashboard.Cron)
+		}
+		tasks = append(tasks, &task{
+			name:     "面板/" + dashboard.Name,
+			schedule: dashboard.schedule,
+			next:     &dashboard.next,
+			run: func(ctx context.Context) {
+				if err := s.dashboardUpdate(ctx, dashboard); err != nil {
+					logs.Warnf("[面板] [%s] 更新失败: %v", dashboard.Name, err)
+				}
+			},
+		})
+	}
+	return
+}
+
+// 面板名称用作 dashboards 中的目录名, 不能包含路径
+func dashboardName(name string) error {
+	if name == "" || name == DASHBOARD_CURRENT || strings.ContainsAny(name, `/\.`) || filepath.Base(name) != name {
+		return fmt.Errorf("面板名称 %q 无效", name)
+	}
+	return nil
+}
+
+// 启动时准备面板, 当前面板未安装时下载
+func (s *Service) dashboardPrepare(ctx context.Context) {
+	name := s.config.Dashboard
+	if name == "" {
+		return
+	}
+	if err := dashboardName(name); err != nil {
+		logs.Warnf("[面板] %v", err)
+		return
+	}
+
+	if _, err := os.Stat(s.pathResolve(DASHBOARD_DIR, name)); err == nil {
+		if err = s.DashboardUse(name); err != nil {
+			logs.Warnf("[面板] [%s] 切换失败: %v", name, err)
+		}
+		return
+	}
+
+	dashboard, _ := lo.Find(s.config.Dashboards, func(it *Dashboard) bool { return strings.EqualFold(it.Name, name) })
+	if dashboard == nil {
+		logs.Warnf("[面板] [%s] 不存在", name)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.dashboardUpdate(ctx, dashboard); err != nil {
+			logs.Warnf("[面板] [%s] 安装失败: %v", name, err)
+		}
+	}()
+}
+
+// 更新指定名称的面板
+func (s *Service) DashboardUpdate(ctx context.Context, name string) (err error) {
+	s.mu.RLock()
+	dashboard, _ := lo.Find(s.config.Dashboards, func(it *Dashboard) bool { return strings.EqualFold(it.Name, name) })
+	s.mu.RUnlock()
+	if dashboard == nil {
+		return fmt.Errorf("面板 %s 不存在", name)
+	}
+	return s.dashboardUpdate(ctx, dashboard)
+}
+
+// 下载, 校验并解压面板, 解压完成后替换已安装的目录
+func (s *Service) dashboardUpdate(ctx context.Context, dashboard *Dashboard) (err error) {
+	if err = dashboardName(dashboard.Name); err != nil {
+		return
+	}
+	if dashboard.Url == "" {
+		return fmt.Errorf("链接为空")
+	}
+
+	var (
+		dir     = s.pathResolve(DASHBOARD_DIR)
+		target  = filepath.Join(dir, dashboard.Name)
+		archive = target + ".download"
+		temp    = target + ".update"
+		old     = target + ".old"
+	)
+	defer os.Remove(archive)
+	defer os.RemoveAll(temp)
+
+	//发布包可能比较大, 不限制单次请求的时间, 只限制总时间
+	logs.Infof("[面板] [%s] 下载... %s", dashboard.Name, redactUrl(dashboard.Url))
+	dlCtx, cancel := context.WithTimeout(ctx, DEFAULT_DASHBOARD_TIMEOUT)
+	defer cancel()
+	client := &http.Client{Transport: newTransport()}
+	if _, err = downloadWith(dlCtx, client, "", dashboard.Url, nil, "", archive); err != nil {
+		return
+	}
+
+	if dashboard.Sha256 != "" {
+		var sum string
+		if sum, err = fileSha256(archive); err != nil {
+			return
+		}
+		if !strings.EqualFold(sum, dashboard.Sha256) {
+			return fmt.Errorf("校验失败, 期望 %s, 实际 %s", dashboard.Sha256, sum)
+		}
+	}
+
+	logs.Infof("[面板] [%s] 解压...", dashboard.Name)
+	os.RemoveAll(temp)
+	if err = extract(archive, temp); err != nil {
+		return
+	}
+
+	os.RemoveAll(old)
+	if _, e := os.Stat(target); e == nil {
+		if err = os.Rename(target, old); err != nil {
+			return
+		}
+	}
+	if err = os.Rename(temp, target); err != nil {
+		os.Rename(old, target)
+		return
+	}
+	os.RemoveAll(old)
+
+	logs.Infof("[面板] [%s] 更新完成", dashboard.Name)
+
+	//是当前面板, 或者还没有选择过面板
+	s.mu.RLock()
+	current := s.config.Dashboard
+	s.mu.RUnlock()
+	_, e := os.Lstat(filepath.Join(dir, DASHBOARD_CURRENT))
+	if strings.EqualFold(current, dashboard.Name) || os.IsNotExist(e) {
+		err = s.DashboardUse(dashboard.Name)
+	}
+	return
+}
+
+// 切换当前面板, 替换 dashboards/current 中记录的名称
+func (s *Service) DashboardUse(name string) (err error) {
+	if err = dashboardName(name); err != nil {
+		return
+	}
+	dir := s.pathResolve(DASHBOARD_DIR)
+	if stat, e := os.Stat(filepath.Join(dir, name)); e != nil || !stat.IsDir() {
+		return fmt.Errorf("面板 %s 未安装", name)
+	}
+
+	//Windows 创建符号链接需要权限, 只记录名称
+	current := filepath.Join(dir, DASHBOARD_CURRENT)
+	temp := current + ".tmp"
+	if err = os.WriteFile(temp, []byte(name+"\n"), 0644); err != nil {
+		return
+	}
+	if err = os.Rename(temp, current); err != nil {
+		os.Remove(temp)
+		return
+	}
+
+	logs.Infof("[面板] 当前面板: %s", name)
+	return
+}
+
+// 已安装的面板列表
+func (s *Service) DashboardList() (list []DashboardInfo, err error) {
+	dir := s.pathResolve(DASHBOARD_DIR)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+
+	current := dashboardCurrent(dir)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || strings.Contains(name, ".") {
+			continue
+		}
+		info := DashboardInfo{Name: name, Current: name == current}
+		if stat, _ := entry.Info(); stat != nil {
+			info.Updated = stat.ModTime()
+		}
+		list = append(list, info)
+	}
+	return
+}
+
+// 当前面板的名称, 兼容旧版本的符号链接
+func dashboardCurrent(dir string) (name string) {
+	fn := filepath.Join(dir, DASHBOARD_CURRENT)
+	if target, err := os.Readlink(fn); err == nil {
+		name = filepath.Base(target)
+	} else if data, err := os.ReadFile(fn); err == nil {
+		name = strings.TrimSpace(string(data))
+	}
+	if dashboardName(name) != nil {
+		name = ""
+	}
+	return
+}
+
+// 在 /ui 提供当前面板的静态文件, 每次请求读取当前面板, 切换后立即生效
+func (s *Service) dashboardHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dir := s.pathResolve(DASHBOARD_DIR)
+		name := dashboardCurrent(dir)
+		if name == "" {
+			http.NotFound(w, r)
+			return
+		}
+		http.StripPrefix("/ui", http.FileServer(http.Dir(filepath.Join(dir, name)))).ServeHTTP(w, r)
+	})
+}
+
+func fileSha256(fn string) (sum string, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+	return
+}
+
+// 解压 zip 或 tar.gz, 只有一个顶层目录时去掉该目录
+func extract(archive, dst string) (err error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	magic, _ := bufio.NewReader(f).Peek(4)
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	type entry struct {
+		name string
+		dir  bool
+		open func() (io.ReadCloser, error)
+	}
+	var entries []entry
+
+	switch {
+	case len(magic) >= 4 && string(magic[:4]) == "PK\x03\x04":
+		var stat os.FileInfo
+		if stat, err = f.Stat(); err != nil {
+			return
+		}
+		var zr *zip.Reader
+		if zr, err = zip.NewReader(f, stat.Size()); err != nil {
+			return
+		}
+		for _, it := range zr.File {
+			it := it
+			entries = append(entries, entry{name: it.Name, dir: it.FileInfo().IsDir(), open: it.Open})
+		}
+	case len(magic) >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(f); err != nil {
+			return
+		}
+		defer gr.Close()
+		tr := tar.NewReader(gr)
+		for {
+			hdr, e := tr.Next()
+			if e == io.EOF {
+				break
+			}
+			if e != nil {
+				return e
+			}
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				entries = append(entries, entry{name: hdr.Name, dir: true})
+			case tar.TypeReg:
+				//tar只能顺序读取, 先读到内存
+				data, e := io.ReadAll(tr)
+				if e != nil {
+					return e
+				}
+				entries = append(entries, entry{name: hdr.Name, open: func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(data)), nil
+				}})
+			}
+		}
+	default:
+		return fmt.Errorf("不支持的压缩格式")
+	}
+
+	//去掉唯一的顶层目录
+	strip := ""
+	roots := lo.Uniq(lo.Map(entries, func(it entry, _ int) string {
+		return strings.SplitN(strings.TrimPrefix(path.Clean("/"+it.name), "/"), "/", 2)[0]
+	}))
+	if len(roots) == 1 && lo.ContainsBy(entries, func(it entry) bool { return strings.Contains(strings.Trim(it.name, "/"), "/") }) {
+		strip = roots[0] + "/"
+	}
+
+	for _, it := range entries {
+		name := strings.TrimPrefix(strings.TrimPrefix(path.Clean("/"+it.name), "/"), strip)
+		if name == "" || name == strings.TrimSuffix(strip, "/") {
+			continue
+		}
+		target := filepath.Join(dst, filepath.FromSlash(name))
+
+		if it.dir {
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return
+			}
+			continue
+		}
+
+		var src io.ReadCloser
+		if src, err = it.open(); err != nil {
+			return
+		}
+		err = readToFile(src, target, true)
+		src.Close()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,202 @@
+package clash
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type archiveFile struct {
+	name string
+	body string //以 / 结尾的 name 是目录
+}
+
+func writeZip(t *testing.T, fn string, files []archiveFile) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, it := range files {
+		w, err := zw.Create(it.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(it.body))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, fn string, files []archiveFile) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, it := range files {
+		hdr := &tar.Header{Name: it.name, Mode: 0644, Size: int64(len(it.body)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(it.name, "/") {
+			hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeDir, 0755, 0
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(it.body))
+	}
+	tw.Close()
+	gw.Close()
+	if err := os.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 解压后的文件列表, 相对于 dir
+func listFiles(t *testing.T, dir string) (files []string) {
+	filepath.Walk(dir, func(fn string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, fn)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []archiveFile
+		want  []string
+	}{
+		{
+			name:  "去掉唯一的顶层目录",
+			files: []archiveFile{{name: "dist/"}, {name: "dist/index.html", body: "<html>"}, {name: "dist/js/app.js", body: "js"}},
+			want:  []string{"index.html", "js/app.js"},
+		},
+		{
+			name:  "多个顶层目录",
+			files: []archiveFile{{name: "a/index.html", body: "a"}, {name: "b/index.html", body: "b"}},
+			want:  []string{"a/index.html", "b/index.html"},
+		},
+		{
+			name:  "顶层只有文件",
+			files: []archiveFile{{name: "index.html", body: "<html>"}},
+			want:  []string{"index.html"},
+		},
+		{
+			name:  "路径不能跳出目标目录",
+			files: []archiveFile{{name: "../../evil.txt", body: "x"}, {name: "/abs.txt", body: "y"}, {name: "ok/../index.html", body: "z"}},
+			want:  []string{"abs.txt", "evil.txt", "index.html"},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, format := range []string{"zip", "tar.gz"} {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				dir := t.TempDir()
+				archive := filepath.Join(dir, "archive")
+				if format == "zip" {
+					writeZip(t, archive, tt.files)
+				} else {
+					writeTarGz(t, archive, tt.files)
+				}
+
+				dst := filepath.Join(dir, "out")
+				if err := extract(archive, dst); err != nil {
+					t.Fatalf("extract: %v", err)
+				}
+				if got := listFiles(t, dst); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+				if got := listFiles(t, dir); len(got) != len(tt.want)+1 {
+					t.Errorf("目标目录外有文件: %v", got)
+				}
+			})
+		}
+	}
+}
+
+func TestExtractUnsupported(t *testing.T) {
+	for _, data := range []string{"", "plain text", "PK", "\x1f\x8bbroken"} {
+		fn := filepath.Join(t.TempDir(), "archive")
+		os.WriteFile(fn, []byte(data), 0644)
+		if err := extract(fn, filepath.Join(t.TempDir(), "out")); err == nil {
+			t.Errorf("%q: 应该失败", data)
+		}
+	}
+}
+
+func TestDashboardName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"yacd", true},
+		{"metacubexd", true},
+		{"", false},
+		{"current", false},
+		{"..", false},
+		{"../../etc", false},
+		{"a/b", false},
+		{`a\b`, false},
+		{"yacd.old", false},
+	}
+	for _, tt := range tests {
+		if err := dashboardName(tt.name); (err == nil) != tt.ok {
+			t.Errorf("dashboardName(%q) = %v, want ok=%v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestDashboardUse(t *testing.T) {
+	s := New(t.TempDir())
+	dir := s.pathResolve(DASHBOARD_DIR)
+	for _, name := range []string{"yacd", "metacubexd"} {
+		os.MkdirAll(filepath.Join(dir, name), 0755)
+		os.WriteFile(filepath.Join(dir, name, "app.js"), []byte(name), 0644)
+	}
+
+	get := func(path string) (int, string) {
+		w := httptest.NewRecorder()
+		s.dashboardHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code, w.Body.String()
+	}
+	if code, _ := get("/ui/"); code != http.StatusNotFound {
+		t.Errorf("未选择面板: %d", code)
+	}
+
+	if err := s.DashboardUse("missing"); err == nil {
+		t.Errorf("未安装的面板应该失败")
+	}
+	for _, name := range []string{"yacd", "metacubexd"} {
+		if err := s.DashboardUse(name); err != nil {
+			t.Fatal(err)
+		}
+		if current := dashboardCurrent(dir); current != name {
+			t.Errorf("current: %q, want %q", current, name)
+		}
+		if code, body := get("/ui/app.js"); code != http.StatusOK || body != name {
+			t.Errorf("/ui/app.js: %d %q, want %q", code, body, name)
+		}
+	}
+	if code, _ := get("/ui/../config.yaml"); code == http.StatusOK {
+		t.Errorf("不能访问面板目录外的文件")
+	}
+
+	list, err := s.DashboardList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || !list[0].Current || list[0].Name != "metacubexd" || list[1].Current {
+		t.Errorf("list: %+v", list)
+	}
+}
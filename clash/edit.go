@@ -0,0 +1,94 @@
+package clash
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 修改 config.yaml, 保留注释和顺序; 订阅列表已加密时修改后重新加密
+func (s *Service) configEdit(edit func(root *yaml.Node) error) (err error) {
+	return s.configEditEncrypted(func(root *yaml.Node, _ *bool) error { return edit(root) })
+}
+
+// 修改 config.yaml, 可以通过 encrypted 修改订阅列表是否加密
+func (s *Service) configEditEncrypted(edit func(root *yaml.Node, encrypted *bool) error) (err error) {
+	fn := s.pathResolve(CONFIG_FN)
+	data, err := os.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s 格式错误", CONFIG_FN)
+	}
+
+	encrypted, err := subscribeDecrypt(root)
+	if err != nil {
+		return
+	}
+	if err = edit(root, &encrypted); err != nil {
+		return
+	}
+	if encrypted {
+		if err = subscribeEncrypt(root); err != nil {
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
+		return
+	}
+	enc.Close()
+
+	if err = os.WriteFile(fn+".tmp", buf.Bytes(), 0644); err != nil {
+		return
+	}
+	if err = os.Rename(fn+".tmp", fn); err != nil {
+		os.Remove(fn + ".tmp")
+		return
+	}
+
+	//重新加载
+	return s.load()
+}
+
+// 映射节点中的值, 不存在时返回 nil
+func mappingGet(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// 设置映射节点中的值, 已存在时替换
+func mappingSet(node *yaml.Node, key string, value any) {
+	var v yaml.Node
+	if err := v.Encode(value); err != nil {
+		v = yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(value)}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			//保留原来的注释
+			v.HeadComment, v.LineComment, v.FootComment = node.Content[i+1].HeadComment, node.Content[i+1].LineComment, node.Content[i+1].FootComment
+			node.Content[i+1] = &v
+			return
+		}
+	}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &v)
+}
@@ -0,0 +1,232 @@
+package clash
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outboundgroup"
+	"github.com/Dreamacro/clash/adapter/provider"
+	"github.com/Dreamacro/clash/constant"
+	types "github.com/Dreamacro/clash/constant/provider"
+	R "github.com/Dreamacro/clash/rule"
+	"github.com/dlclark/regexp2"
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/samber/lo"
+)
+
+const (
+	DEFAULT_GROUPS_NAME     = "Hlash" //订阅中常有名为 PROXY 的策略组, 重名时不会生成
+	DEFAULT_GROUPS_AUTO     = "Auto"
+	DEFAULT_GROUPS_TYPE     = "url-test"
+	DEFAULT_GROUPS_INTERVAL = 5 * time.Minute
+)
+
+// 默认的地区
+var defaultRegions = []*Region{
+	{Name: "香港", Pattern: "HK|香港|Hong ?Kong"},
+	{Name: "台湾", Pattern: "TW|台湾|Taiwan"},
+	{Name: "日本", Pattern: "JP|日本|Japan"},
+	{Name: "新加坡", Pattern: "SG|新加坡|Singapore"},
+	{Name: "美国", Pattern: "US|美国|United States"},
+}
+
+// 按地区和延迟自动生成策略组, 适合只有节点列表的订阅
+// 生成: 顶层 select(自动, 各地区, 订阅中的策略组, DIRECT), 全部节点的 url-test, 每个地区一个策略组
+type Groups struct {
+	Enable   bool
+	Name     string        //顶层 select 策略组的名称, 默认 Hlash
+	Auto     string        //全部节点的 url-test 策略组的名称, 默认 Auto
+	Type     string        //地区策略组的类型: url-test(默认), fallback 或 select
+	Url      string        //测试链接, 默认 http://www.gstatic.com/generate_204
+	Interval time.Duration //测试间隔, 默认5m
+	Regions  []*Region     //地区, 为空时使用默认的香港, 台湾, 日本, 新加坡, 美国
+}
+
+// 地区, 节点名称匹配 Pattern 的放在同一个策略组; proxy-providers 中的节点通过 filter 匹配
+type Region struct {
+	Name    string
+	Pattern string
+}
+
+// 当前订阅使用的生成配置, 订阅中的 groups 优先
+func (s *Service) groupsConfig(sub *Subscribe) *Groups {
+	if sub != nil && sub.Groups != nil {
+		return sub.Groups
+	}
+	return &s.config.Groups
+}
+
+// 在解析后的配置中加入生成的策略组, 订阅没有规则时添加 MATCH,<顶层策略组>
+// offline 为真时(check, 未运行时的 config show)自动测试的策略组按 select 创建, 不在后台测试节点, 输出时仍是原来的类型
+func (s *Service) groupGenerate(sub *Subscribe, offline bool) {
+	s.groups = nil
+	g := s.groupsConfig(sub)
+	if !g.Enable || s.clash == nil {
+		return
+	}
+	cfg := s.clash
+
+	var (
+		name, auto = g.names()
+		groupType  = lo.Ternary(g.Type != "", g.Type, DEFAULT_GROUPS_TYPE)
+		url        = lo.Ternary(g.Url != "", g.Url, DEFAULT_HEALTH_URL)
+		interval   = int(lo.Ternary(g.Interval > 0, g.Interval, DEFAULT_GROUPS_INTERVAL) / time.Second)
+		regions    = lo.Ternary(len(g.Regions) > 0, g.Regions, defaultRegions)
+
+		global = globalNames(cfg.Proxies)
+		nodes  = lo.Filter(global, func(it string, _ int) bool { return isNode(cfg.Proxies[it]) })
+		groups = lo.Filter(global, func(it string, _ int) bool { return isGroup(cfg.Proxies[it]) })
+		uses   = lo.Filter(lo.Keys(cfg.Providers), func(it string, _ int) bool { return cfg.Providers[it].VehicleType() != types.Compatible })
+		//proxy-providers 在解析时已经加载, 用于判断地区是否有节点
+		provided = lo.FlatMap(uses, func(it string, _ int) []string {
+			return lo.Map(cfg.Providers[it].Proxies(), func(p constant.Proxy, _ int) string { return p.Name() })
+		})
+	)
+	if len(nodes) == 0 && len(uses) == 0 {
+		return
+	}
+
+	var (
+		generated []string
+		mappings  = map[string]map[string]any{}
+	)
+	add := func(mapping map[string]any) bool {
+		groupName := mapping["name"].(string)
+		if _, exist := cfg.Proxies[groupName]; exist {
+			logs.Warnf("[策略组] [%s] 订阅中已存在, 不生成", groupName)
+			return false
+		}
+		parse := mapping
+		if offline {
+			parse = lo.Assign(mapping, map[string]any{"type": "select"})
+		}
+		group, err := outboundgroup.ParseProxyGroup(parse, cfg.Proxies, cfg.Providers)
+		if err != nil {
+			logs.Warnf("[策略组] [%s] 生成失败: %v", groupName, err)
+			return false
+		}
+		if pd, ok := cfg.Providers[groupName]; ok && pd.VehicleType() == types.Compatible {
+			if err = pd.Initial(); err != nil {
+				delete(cfg.Providers, groupName)
+				logs.Warnf("[策略组] [%s] 生成失败: %v", groupName, err)
+				return false
+			}
+		}
+		cfg.Proxies[groupName] = adapter.NewProxy(group)
+		generated = append(generated, groupName)
+		mappings[groupName] = mapping
+		return true
+	}
+	mapping := func(groupName, groupType string, proxies []string, filter string) map[string]any {
+		m := map[string]any{"name": groupName, "type": groupType, "url": url, "interval": interval}
+		if len(proxies) > 0 {
+			m["proxies"] = proxies
+		}
+		if len(uses) > 0 {
+			m["use"] = uses
+			if filter != "" {
+				m["filter"] = filter
+			}
+		}
+		return m
+	}
+
+	add(mapping(auto, "url-test", nodes, ""))
+
+	for _, region := range regions {
+		re, err := regexp2.Compile(region.Pattern, regexp2.None)
+		if err != nil {
+			logs.Warnf("[策略组] [%s] 正则错误: %v", region.Name, err)
+			continue
+		}
+		match := func(it string, _ int) bool { ok, _ := re.MatchString(it); return ok }
+		matched := lo.Filter(nodes, match)
+		if len(matched) == 0 && !lo.ContainsBy(provided, func(it string) bool { return match(it, 0) }) {
+			continue
+		}
+		add(mapping(region.Name, groupType, matched, region.Pattern))
+	}
+
+	top := append(append([]string{}, generated...), groups...)
+	if !add(map[string]any{"name": name, "type": "select", "proxies": append(top, "DIRECT")}) {
+		name = ""
+	}
+	if len(generated) == 0 {
+		return
+	}
+
+	//重建 GLOBAL, 生成的策略组放在订阅的策略组前面, 顶层策略组在最前
+	if name != "" {
+		generated = append([]string{name}, generated[:len(generated)-1]...)
+	}
+	order := lo.Without(global, append(generated, "GLOBAL")...)
+	i := len(order)
+	if len(groups) > 0 {
+		i = lo.IndexOf(order, groups[0])
+	}
+	order = append(order[:i], append(generated, order[i:]...)...)
+	ps := lo.Map(order, func(it string, _ int) constant.Proxy { return cfg.Proxies[it] })
+	pd, _ := provider.NewCompatibleProvider(provider.ReservedName, ps, provider.NewHealthCheck(ps, "", 0, true))
+	cfg.Providers[provider.ReservedName] = pd
+	cfg.Proxies["GLOBAL"] = adapter.NewProxy(outboundgroup.NewSelector(&outboundgroup.GroupCommonOption{Name: "GLOBAL"}, []types.ProxyProvider{pd}))
+
+	if len(cfg.Rules) == 0 && name != "" {
+		cfg.Rules = append(cfg.Rules, R.NewMatch(name))
+	}
+	s.groups = lo.Map(generated, func(it string, _ int) map[string]any { return mappings[it] })
+	logs.Infof("[策略组] 生成: %v", generated)
+}
+
+// 顶层和全部节点的策略组的名称, 未设置时使用默认值
+func (g *Groups) names() (name, auto string) {
+	return lo.Ternary(g.Name != "", g.Name, DEFAULT_GROUPS_NAME), lo.Ternary(g.Auto != "", g.Auto, DEFAULT_GROUPS_AUTO)
+}
+
+// 与订阅中的策略组或节点重名而没有生成的策略组, 需要在 groupGenerate 之后调用
+func (s *Service) groupConflicts(sub *Subscribe) (top bool, others []string) {
+	g := s.groupsConfig(sub)
+	if !g.Enable || s.clash == nil {
+		return
+	}
+	name, auto := g.names()
+	regions := lo.Map(lo.Ternary(len(g.Regions) > 0, g.Regions, defaultRegions), func(it *Region, _ int) string { return it.Name })
+	conflict := func(it string) bool {
+		_, exist := s.clash.Proxies[it]
+		return exist && !lo.ContainsBy(s.groups, func(m map[string]any) bool { return m["name"] == it })
+	}
+	return conflict(name), lo.Filter(append([]string{auto}, regions...), func(it string, _ int) bool { return conflict(it) })
+}
+
+// GLOBAL 中的名称, 按订阅中的顺序包含全部节点和策略组
+func globalNames(proxies map[string]constant.Proxy) []string {
+	global, ok := proxies["GLOBAL"]
+	if !ok {
+		return nil
+	}
+	var info struct {
+		All []string
+	}
+	data, _ := global.MarshalJSON()
+	json.Unmarshal(data, &info)
+	return info.All
+}
+
+// 检查生成策略组的配置
+func (g *Groups) validate() (errs []string) {
+	switch g.Type {
+	case "", "url-test", "fallback", "select":
+	default:
+		errs = append(errs, fmt.Sprintf("groups.type: 不支持的类型 %s", g.Type))
+	}
+	for _, it := range g.Regions {
+		if it.Name == "" {
+			errs = append(errs, "groups.regions: 名称为空")
+		}
+		if _, err := regexp2.Compile(it.Pattern, regexp2.None); err != nil {
+			errs = append(errs, fmt.Sprintf("groups.regions [%s]: 正则错误: %v", it.Name, err))
+		}
+	}
+	return
+}
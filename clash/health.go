@@ -0,0 +1,213 @@
+package clash
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/constant/provider"
+	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/robfig/cron/v3"
+	"github.com/samber/lo"
+)
+
+const (
+	DEFAULT_HEALTH_URL       = "http://www.gstatic.com/generate_204"
+	DEFAULT_HEALTH_INTERVAL  = 5 * time.Minute
+	DEFAULT_HEALTH_TIMEOUT   = 5 * time.Second
+	DEFAULT_HEALTH_THRESHOLD = 0.5
+)
+
+// 节点健康检查, 当前订阅的可用节点比例过低时按顺序切换到下一个订阅, 之前的订阅恢复后再切换回来
+type Health struct {
+	Enable    bool          //启用
+	Url       string        //测试链接, 默认 http://www.gstatic.com/generate_204
+	Interval  time.Duration //检查间隔, 默认5m
+	Timeout   time.Duration //单个节点的超时时间, 默认5s
+	Threshold float64       //可用节点的比例低于此值时切换, 默认0.5
+}
+
+// 健康检查的计划
+func (s *Service) healthTasks() (tasks []*task) {
+	if !s.config.Health.Enable || len(s.config.Subscribe) == 0 {
+		return
+	}
+
+	interval := lo.Ternary(s.config.Health.Interval > 0, s.config.Health.Interval, DEFAULT_HEALTH_INTERVAL)
+
+	var next time.Time
+	tasks = append(tasks, &task{
+		name:     "健康检查",
+		schedule: cron.Every(interval),
+		next:     &next,
+		run:      s.healthCheck,
+	})
+	return
+}
+
+// 检查当前订阅, 必要时切换
+func (s *Service) healthCheck(ctx context.Context) {
+	if !s.healthMu.TryLock() {
+		logs.Warnf("[健康检查] 上次检查还未结束, 跳过")
+		return
+	}
+	defer s.healthMu.Unlock()
+
+	s.mu.RLock()
+	var (
+		threshold = lo.Ternary(s.config.Health.Threshold > 0, s.config.Health.Threshold, DEFAULT_HEALTH_THRESHOLD)
+		active    = s.config.Current
+		order     = s.healthOrder()
+		index     = lo.IndexOf(order, active)
+		proxies   = healthProxies(tunnel.Proxies(), tunnel.Providers())
+	)
+	s.mu.RUnlock()
+
+	alive, total := s.healthTest(ctx, proxies)
+	logs.Infof("[健康检查] [%s] 可用节点 %d/%d", active, alive, total)
+	//没有节点时无法判断, 不切换
+	healthy := total == 0 || healthRatio(alive, total) >= threshold
+
+	//当前不是首选的订阅, 优先级更高的订阅恢复后切换回去
+	for _, name := range order[:max(index, 0)] {
+		if ctx.Err() != nil {
+			return
+		}
+		if alive, total := s.healthSubscribe(ctx, name); healthRatio(alive, total) >= threshold {
+			s.healthSwitch(active, name, fmt.Sprintf("[%s] 已恢复, 可用节点 %d/%d", name, alive, total))
+			return
+		}
+	}
+
+	if healthy || ctx.Err() != nil {
+		return
+	}
+
+	reason := fmt.Sprintf("[%s] 可用节点 %d/%d, 低于 %.0f%%", active, alive, total, threshold*100)
+	for _, name := range order[index+1:] {
+		if ctx.Err() != nil {
+			return
+		}
+		if alive, total := s.healthSubscribe(ctx, name); healthRatio(alive, total) >= threshold {
+			s.healthSwitch(active, name, reason)
+			return
+		}
+		logs.Warnf("[健康检查] [%s] 也不可用", name)
+	}
+	logs.Warnf("[健康检查] %s, 没有可以切换的订阅", reason)
+}
+
+// 切换的顺序: 配置文件中的当前订阅, 然后按订阅列表的顺序; 调用时需要持有 s.mu 的读锁
+func (s *Service) healthOrder() (order []string) {
+	order = append(order, s.preferred)
+	for _, it := range s.config.Subscribe {
+		if it.Name != s.preferred {
+			order = append(order, it.Name)
+		}
+	}
+	return
+}
+
+// 测试未使用的订阅中的节点
+func (s *Service) healthSubscribe(ctx context.Context, name string) (alive, total int) {
+	cfg, err := executor.ParseWithPath(s.pathResolve(SUBSCRIBE_DIR, name+".yaml"))
+	if err != nil {
+		logs.Warnf("[健康检查] [%s] 加载失败: %v", name, err)
+		return
+	}
+	alive, total = s.healthTest(ctx, healthProxies(cfg.Proxies, cfg.Providers))
+	logs.Infof("[健康检查] [%s] 可用节点 %d/%d", name, alive, total)
+	return
+}
+
+// 检查期间手动切换过订阅时放弃, 以手动切换的为准
+func (s *Service) healthSwitch(from, to, reason string) {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+
+	if current := s.Current(); current != from {
+		logs.Infof("[健康检查] 检查期间已切换到 [%s], 放弃切换到 [%s]", current, to)
+		return
+	}
+
+	logs.Warnf("[健康检查] 切换订阅 %s => %s: %s", from, to, reason)
+	if err := s.subscribeApply(to, false); err != nil {
+		logs.Errorf("[健康检查] 切换失败: %v", err)
+		return
+	}
+	s.stateFailover(&Failover{Time: time.Now(), From: from, To: to, Reason: reason})
+}
+
+// 需要测试的节点, 包括 proxy-providers 中的节点, 按名称去重
+func healthProxies(proxies map[string]constant.Proxy, providers map[string]provider.ProxyProvider) (list []constant.Proxy) {
+	list = lo.Values(proxies)
+	for _, p := range providers {
+		list = append(list, p.Proxies()...)
+	}
+	return lo.UniqBy(list, func(it constant.Proxy) string { return it.Name() })
+}
+
+// 并发测试节点的延迟, 返回可用的和总的节点数量
+func (s *Service) healthTest(ctx context.Context, proxies []constant.Proxy) (alive, total int) {
+	s.mu.RLock()
+	health := s.config.Health
+	s.mu.RUnlock()
+
+	var (
+		url     = lo.Ternary(health.Url != "", health.Url, DEFAULT_HEALTH_URL)
+		timeout = lo.Ternary(health.Timeout > 0, health.Timeout, DEFAULT_HEALTH_TIMEOUT)
+		nodes   = lo.Filter(proxies, func(it constant.Proxy, _ int) bool { return isNode(it) })
+		sem     = make(chan struct{}, 16)
+		count   atomic.Int32
+		wg      sync.WaitGroup
+	)
+
+	for _, proxy := range nodes {
+		wg.Add(1)
+		go func(proxy constant.Proxy) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			if _, _, err := proxy.URLTest(ctx, url); err == nil {
+				count.Add(1)
+			}
+		}(proxy)
+	}
+	wg.Wait()
+
+	return int(count.Load()), len(nodes)
+}
+
+// 是否是节点, 不含内置的 DIRECT, REJECT 和策略组
+func isNode(proxy constant.Proxy) bool {
+	switch proxy.Type() {
+	case constant.Direct, constant.Reject:
+		return false
+	}
+	return !isGroup(proxy)
+}
+
+// 是否是策略组
+func isGroup(proxy constant.Proxy) bool {
+	switch proxy.Type() {
+	case constant.Relay, constant.Selector, constant.Fallback, constant.URLTest, constant.LoadBalance:
+		return true
+	}
+	return false
+}
+
+// 没有节点时返回 0, 不会作为切换的目标
+func healthRatio(alive, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(alive) / float64(total)
+}
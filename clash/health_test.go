@@ -0,0 +1,101 @@
+package clash
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/go-chi/chi/v5"
+	"github.com/samber/lo"
+)
+
+// 检查期间手动切换过订阅时不再自动切换
+func TestHealthSwitchStale(t *testing.T) {
+	s := testService(t)
+	if err := s.subscribeSwitch("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	s.healthSwitch("a", "a", "测试")
+	if current := s.Current(); current != "b" {
+		t.Errorf("current: %q, 应保留手动切换的 b", current)
+	}
+}
+
+// 只有 proxy-providers 的订阅也测试其中的节点
+func TestHealthProviderOnly(t *testing.T) {
+	s := testService(t)
+	files := map[string]string{
+		SUBSCRIBE_DIR + "/c.yaml": `proxy-providers:
+  nodes: {type: file, path: ./providers/c.yaml}
+proxy-groups:
+  - {name: PROXY, type: select, use: [nodes]}
+rules:
+  - MATCH,PROXY
+`,
+		CLASH_DIR + "/providers/c.yaml": `proxies:
+  - {name: "HK 01", type: socks5, server: 127.0.0.1, port: 1080}
+  - {name: "JP 01", type: socks5, server: 127.0.0.1, port: 1081}
+`,
+	}
+	for name, data := range files {
+		fn := s.pathResolve(name)
+		os.MkdirAll(filepath.Dir(fn), 0755)
+		if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.config.Subscribe = append(s.config.Subscribe, &Subscribe{Name: "c"})
+	s.config.Health.Timeout = 100 * time.Millisecond
+
+	if _, total := s.healthSubscribe(context.Background(), "c"); total != 2 {
+		t.Errorf("未使用的订阅: %d 个节点", total)
+	}
+
+	if err := s.subscribeSwitch("c"); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.RLock()
+	nodes := lo.Filter(healthProxies(tunnel.Proxies(), tunnel.Providers()), func(it constant.Proxy, _ int) bool { return isNode(it) })
+	s.mu.RUnlock()
+	if len(nodes) != 2 {
+		t.Errorf("当前订阅: %d 个节点", len(nodes))
+	}
+}
+
+// 手动切换的订阅作为首选, 不保存时重新加载后恢复为配置文件中的
+func TestHealthManualSwitch(t *testing.T) {
+	s := testService(t)
+	if err := s.subscribeSwitch("a"); err != nil {
+		t.Fatal(err)
+	}
+	s.preferred = "a"
+
+	router := chi.NewRouter()
+	s.apiRoutes(router)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/switch/b", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("switch: %d %s", w.Code, w.Body)
+	}
+
+	s.mu.RLock()
+	order := s.healthOrder()
+	s.mu.RUnlock()
+	if order[0] != "b" {
+		t.Errorf("手动切换后的顺序: %v", order)
+	}
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if s.preferred != "a" || s.Current() != "a" {
+		t.Errorf("重新加载后: preferred %q, current %q", s.preferred, s.Current())
+	}
+}
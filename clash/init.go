@@ -0,0 +1,174 @@
+package clash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/Dreamacro/clash/config"
+	"github.com/Dreamacro/clash/dns"
+)
+
+// 初始化选项
+type InitOptions struct {
+	Subscribe string //订阅链接, 为空时只写入示例
+	MixedPort int    //混合端口, 为0时使用默认值
+	AllowLan  bool   //允许局域网的连接
+}
+
+// 初始化数据目录, 写入默认的 general.yaml, dns.yaml 和 config.yaml, 已存在的文件不会覆盖
+func Init(homeDir string, opt InitOptions) (created []string, err error) {
+	if err = os.MkdirAll(homeDir, 0755); err != nil {
+		return
+	}
+
+	preset := &config.Config{General: &config.General{}}
+	GeneralDefault()(preset)
+	DNSDefault()(preset)
+
+	if opt.MixedPort != 0 {
+		preset.General.MixedPort = opt.MixedPort
+	}
+	preset.General.AllowLan = opt.AllowLan
+
+	data := map[string]any{
+		"General":   preset.General,
+		"DNS":       preset.DNS,
+		"Secret":    randomSecret(),
+		"Subscribe": opt.Subscribe,
+	}
+
+	files := []struct {
+		name string
+		tpl  string
+	}{
+		{"general.yaml", generalTemplate},
+		{"dns.yaml", dnsTemplate},
+		{CONFIG_FN, configTemplate},
+	}
+
+	for _, it := range files {
+		fn := filepath.Join(homeDir, it.name)
+		if _, e := os.Stat(fn); e == nil || !os.IsNotExist(e) {
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err = template.Must(template.New(it.name).Funcs(templateFuncs).Parse(it.tpl)).Execute(&buf, data); err != nil {
+			return
+		}
+
+		if err = readToFile(&buf, fn, false); err != nil {
+			return
+		}
+		created = append(created, fn)
+	}
+	return
+}
+
+func randomSecret() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+var templateFuncs = template.FuncMap{
+	"ns": func(n dns.NameServer) string {
+		if n.Net == "" || n.Net == "udp" {
+			return n.Addr
+		}
+		return fmt.Sprintf("%s://%s", n.Net, n.Addr)
+	},
+}
+
+const generalTemplate = `# 混合端口
+mixed-port: {{.General.MixedPort}}
+
+# HTTP 代理端口
+port: {{.General.Port}}
+
+# SOCKS5 代理端口
+socks-port: {{.General.SocksPort}}
+
+# Linux 和 macOS 的 redir 代理端口, 透明代理需要
+redir-port: {{.General.RedirPort}}
+
+# 允许局域网的连接
+allow-lan: {{.General.AllowLan}}
+
+# 规则模式：rule（规则） / global（全局代理）/ direct（全局直连）
+mode: {{.General.Mode}}
+
+# 日志输出级别：silent / info / warning / error / debug
+log-level: info
+
+# Clash 的 RESTful API
+external-controller: "{{.General.ExternalController}}"
+
+# RESTful API 的口令
+secret: "{{.Secret}}"
+
+# 静态网页资源（如 clash-dashboard）所在的目录, 相对于 clash 目录, 目录必须存在
+# external-ui: {{.General.ExternalUI}}
+`
+
+const dnsTemplate = `dns:
+  # 启用内核的DNS服务
+  enable: {{.DNS.Enable}}
+
+  ipv6: {{.DNS.IPv6}}
+
+  # DNS服务监听地址, 透明代理劫持DNS时使用
+  listen: "{{.DNS.Listen}}"
+
+  # 模式：redir-host / fake-ip
+  enhanced-mode: {{.DNS.EnhancedMode}}
+
+  # fake-ip 模式使用的地址段
+  # fake-ip-range: 198.18.0.1/16
+
+  nameserver:
+{{- range .DNS.NameServer}}
+    - {{ns .}}
+{{- end}}
+
+  fallback:
+{{- range .DNS.Fallback}}
+    - {{ns .}}
+{{- end}}
+`
+
+const configTemplate = `# 当前使用的订阅名称, 为空时使用第一个
+current: ""
+
+# 订阅列表
+subscribe:
+{{- if .Subscribe}}
+  - name: default
+    url: {{printf "%q" .Subscribe}}
+    cron: "@every 24h"
+{{- else}}
+  # - name: mySubscribe-01
+  #   url: https://url/to/subscribe
+  #   cron: "@every 24h"
+{{- end}}
+
+# 透明代理(仅Linux, 需要iptables)
+transparent:
+  enable: false
+  # 同时代理本机发出的流量
+  local: false
+  # 劫持局域网的DNS请求到内核的DNS服务
+  dns: false
+  # 不代理的目标网段, 为空时使用保留地址段
+  bypass: []
+
+# 出站流量标记, 透明代理启用时默认 255
+mark: 0
+`
@@ -0,0 +1,69 @@
+package clash
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+
+	"github.com/Dreamacro/clash/hub/executor"
+)
+
+func TestInit(t *testing.T) {
+	home := t.TempDir()
+	created, err := Init(home, InitOptions{Subscribe: "https://example.com/sub", MixedPort: 7899, AllowLan: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(created))
+	for _, fn := range created {
+		names = append(names, filepath.Base(fn))
+	}
+	sort.Strings(names)
+	if want := []string{CONFIG_FN, "dns.yaml", "general.yaml"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("created = %v, want %v", names, want)
+	}
+
+	//生成的配置可以被内核解析, 口令是随机的32位十六进制
+	cfg, err := executor.ParseWithPath(filepath.Join(home, "general.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.General.MixedPort != 7899 || !cfg.General.AllowLan {
+		t.Errorf("mixed-port = %d, allow-lan = %v", cfg.General.MixedPort, cfg.General.AllowLan)
+	}
+	if !regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(cfg.General.Secret) {
+		t.Errorf("secret = %q", cfg.General.Secret)
+	}
+	s := New(home)
+	if err = s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.config.Subscribe) != 1 || s.config.Subscribe[0].Url != "https://example.com/sub" {
+		t.Errorf("subscribe = %+v", s.config.Subscribe)
+	}
+
+	//已存在的文件不会覆盖, 再次初始化时使用新的口令也不影响
+	before := map[string][]byte{}
+	for _, fn := range created {
+		before[fn], _ = os.ReadFile(fn)
+	}
+	os.Remove(filepath.Join(home, "dns.yaml"))
+	created, err = Init(home, InitOptions{MixedPort: 1234})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(created) != 1 || filepath.Base(created[0]) != "dns.yaml" {
+		t.Errorf("created = %v, 只应该重新创建 dns.yaml", created)
+	}
+	for fn, data := range before {
+		if filepath.Base(fn) == "dns.yaml" {
+			continue
+		}
+		if after, _ := os.ReadFile(fn); string(after) != string(data) {
+			t.Errorf("%s 被覆盖", filepath.Base(fn))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package clash
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/Dreamacro/clash/log"
+	"github.com/hxnas/hlash/pkg/logs"
+)
+
+const LOG_FN = "logs/hlash.log"
+
+// 日志
+type Log struct {
+	Level      string        //hlash 自身的日志级别, 与内核的 log-level 无关
+	Json       bool          //以JSON格式输出
+	File       string        //日志文件, 相对于数据目录或绝对路径, 默认 logs/hlash.log, 设为 "-" 时不写文件
+	MaxSize    int           `yaml:"max-size"`     //单个日志文件的大小(MB), 超过后轮转
+	MaxFileAge time.Duration `yaml:"max-file-age"` //单个日志文件的写入时间, 超过后轮转
+	MaxAge     time.Duration `yaml:"max-age"`      //轮转后的日志保留时间
+	MaxBackups int           `yaml:"max-backups"`  //轮转后的日志保留个数
+}
+
+// 设置命令行指定的日志级别, 优先于配置文件
+func (s *Service) SetLogLevel(level string) {
+	s.logLevel = level
+}
+
+// 按配置设置日志, 内核的日志也写入日志文件
+func (s *Service) logSetup() (err error) {
+	opt := logs.Options{
+		Level:      s.config.Log.Level,
+		Json:       s.config.Log.Json,
+		File:       s.config.Log.File,
+		MaxSize:    s.config.Log.MaxSize,
+		MaxFileAge: s.config.Log.MaxFileAge,
+		MaxAge:     s.config.Log.MaxAge,
+		MaxBackups: s.config.Log.MaxBackups,
+	}
+	if s.logLevel != "" {
+		opt.Level = s.logLevel
+	}
+
+	switch opt.File {
+	case "-":
+		opt.File = ""
+	case "":
+		opt.File = s.pathResolve(LOG_FN)
+	default:
+		if !filepath.IsAbs(opt.File) {
+			opt.File = s.pathResolve(opt.File)
+		}
+	}
+
+	return logs.Setup(opt)
+}
+
+// 转发内核的日志到日志文件, 直到 done 关闭
+func logCore(done <-chan struct{}) {
+	sub := log.Subscribe()
+	go func() {
+		<-done
+		log.UnSubscribe(sub)
+	}()
+
+	go func() {
+		for it := range sub {
+			if ev, ok := it.(log.Event); ok && ev.LogLevel >= log.Level() {
+				logs.Core(logs.Level(ev.LogLevel), ev.Payload)
+			}
+		}
+	}()
+}
@@ -0,0 +1,26 @@
+package clash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hxnas/hlash/pkg/logs"
+)
+
+// 日志文件可以是相对于数据目录的路径或绝对路径
+func TestLogSetup(t *testing.T) {
+	s := New(t.TempDir())
+	abs := filepath.Join(t.TempDir(), "abs.log")
+	defer logs.Close()
+
+	for file, want := range map[string]string{"": s.pathResolve(LOG_FN), "my.log": s.pathResolve("my.log"), abs: abs} {
+		s.config.Log.File = file
+		if err := s.logSetup(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(want); err != nil {
+			t.Errorf("%q: %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package clash
+
+import "syscall"
+
+// 给连接设置SO_MARK
+func markControl(mark int) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) (err error) {
+		var innerErr error
+		if err = c.Control(func(fd uintptr) {
+			innerErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, mark)
+		}); err == nil {
+			err = innerErr
+		}
+		return
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package clash
+
+import "syscall"
+
+// 非Linux系统不支持SO_MARK
+func markControl(int) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,183 @@
+package clash
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/config"
+	"github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+	"github.com/samber/lo"
+)
+
+// 订阅响应头 subscription-userinfo 中的流量信息
+type Userinfo struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+	Total    int64 `json:"total"`
+	Expire   int64 `json:"expire,omitempty"` //到期时间, Unix 时间戳
+}
+
+// 解析 subscription-userinfo, 格式如 upload=123; download=456; total=789; expire=1700000000
+func parseUserinfo(s string) (info *Userinfo) {
+	if s == "" {
+		return
+	}
+	info = &Userinfo{}
+	for _, it := range strings.Split(s, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(it), "=")
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(k)) {
+		case "upload":
+			info.Upload = n
+		case "download":
+			info.Download = n
+		case "total":
+			info.Total = n
+		case "expire":
+			info.Expire = n
+		}
+	}
+	return
+}
+
+// 配置中的节点数量, 不含内置的 DIRECT, REJECT 和策略组
+func proxyCount(cfg *config.Config) int {
+	return lo.CountBy(lo.Values(cfg.Proxies), isNode)
+}
+
+// Prometheus 格式的指标
+func (s *Service) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		m     = &metrics{}
+		state = s.stateSnapshot()
+		now   = time.Now()
+	)
+
+	m.add("hlash_start_time_seconds", "gauge", "启动时间", unix(state.Started))
+
+	s.mu.RLock()
+	subscribes := s.config.Subscribe
+	s.mu.RUnlock()
+	for _, subscribe := range subscribes {
+		name := subscribe.Name
+		m.add("hlash_subscribe_current", "gauge", "是否当前使用的订阅", lo.Ternary[float64](name == state.Current, 1, 0), "name", name)
+		if stat, _ := os.Stat(s.pathResolve(SUBSCRIBE_DIR, name+".yaml")); stat != nil {
+			m.add("hlash_subscribe_file_modified_timestamp_seconds", "gauge", "订阅文件的修改时间", unix(stat.ModTime()), "name", name)
+		}
+
+		st := state.Subscribe[name]
+		if st == nil {
+			continue
+		}
+		m.add("hlash_subscribe_success", "gauge", "最后一次更新是否成功", lo.Ternary[float64](st.Success, 1, 0), "name", name)
+		if !st.LastSuccess.IsZero() {
+			m.add("hlash_subscribe_last_success_timestamp_seconds", "gauge", "最后一次更新成功的时间", unix(st.LastSuccess), "name", name)
+		}
+		if !st.LastFailure.IsZero() {
+			m.add("hlash_subscribe_last_failure_timestamp_seconds", "gauge", "最后一次更新失败的时间", unix(st.LastFailure), "name", name)
+		}
+		m.add("hlash_subscribe_download_duration_seconds", "gauge", "最后一次下载的耗时", st.Duration.Seconds(), "name", name)
+		m.add("hlash_subscribe_proxies", "gauge", "订阅中的节点数量", float64(st.Proxies), "name", name)
+		if info := st.Userinfo; info != nil {
+			m.add("hlash_subscribe_upload_bytes", "gauge", "订阅已用上传流量", float64(info.Upload), "name", name)
+			m.add("hlash_subscribe_download_bytes", "gauge", "订阅已用下载流量", float64(info.Download), "name", name)
+			m.add("hlash_subscribe_total_bytes", "gauge", "订阅总流量", float64(info.Total), "name", name)
+			if info.Expire > 0 {
+				m.add("hlash_subscribe_expire_timestamp_seconds", "gauge", "订阅到期时间", float64(info.Expire), "name", name)
+			}
+		}
+	}
+
+	if stat, _ := os.Stat(constant.Path.MMDB()); stat != nil {
+		m.add("hlash_mmdb_age_seconds", "gauge", "MMDB 文件距上次修改的时间", now.Sub(stat.ModTime()).Seconds())
+	}
+
+	snapshot := statistic.DefaultManager.Snapshot()
+	up, down := statistic.DefaultManager.Now()
+	m.add("hlash_core_upload_bytes_total", "counter", "内核上传的总流量", float64(snapshot.UploadTotal))
+	m.add("hlash_core_download_bytes_total", "counter", "内核下载的总流量", float64(snapshot.DownloadTotal))
+	m.add("hlash_core_upload_bytes_per_second", "gauge", "内核当前的上传速度", float64(up))
+	m.add("hlash_core_download_bytes_per_second", "gauge", "内核当前的下载速度", float64(down))
+	m.add("hlash_core_connections", "gauge", "内核的活动连接数", float64(len(snapshot.Connections)))
+
+	//只导出测过延迟的节点
+	s.mu.RLock()
+	proxies := tunnel.Proxies()
+	s.mu.RUnlock()
+	for name, proxy := range proxies {
+		history := proxy.DelayHistory()
+		if len(history) == 0 {
+			continue
+		}
+		typ := proxy.Type().String()
+		m.add("hlash_proxy_alive", "gauge", "节点最后一次测试是否可用", lo.Ternary[float64](proxy.Alive(), 1, 0), "proxy", name, "type", typ)
+		if proxy.Alive() {
+			m.add("hlash_proxy_delay_milliseconds", "gauge", "节点最后一次测试的延迟", float64(history[len(history)-1].Delay), "proxy", name, "type", typ)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.writeTo(w)
+}
+
+// Prometheus 文本格式的指标集合, 同名的指标输出在一起
+type metrics struct {
+	names   []string
+	help    map[string][2]string
+	samples map[string][]string
+}
+
+func (m *metrics) add(name, typ, help string, value float64, labels ...string) {
+	if m.help == nil {
+		m.help = map[string][2]string{}
+		m.samples = map[string][]string{}
+	}
+	if _, ok := m.help[name]; !ok {
+		m.names = append(m.names, name)
+		m.help[name] = [2]string{typ, help}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(name)
+	if len(labels) > 1 {
+		sb.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			fmt.Fprintf(&sb, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		sb.WriteByte('}')
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	m.samples[name] = append(m.samples[name], sb.String())
+}
+
+func (m *metrics) writeTo(w http.ResponseWriter) {
+	for _, name := range m.names {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, m.help[name][1])
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, m.help[name][0])
+		samples := m.samples[name]
+		sort.Strings(samples)
+		for _, it := range samples {
+			fmt.Fprintln(w, it)
+		}
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func unix(t time.Time) float64 {
+	return float64(t.UnixMilli()) / 1000
+}
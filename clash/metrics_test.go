@@ -0,0 +1,32 @@
+package clash
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseUserinfo(t *testing.T) {
+	tests := []struct {
+		header string
+		want   *Userinfo
+	}{
+		{"", nil},
+		{
+			"upload=123; download=456; total=789; expire=1700000000",
+			&Userinfo{Upload: 123, Download: 456, Total: 789, Expire: 1700000000},
+		},
+		{"upload=1;download=2;total=3", &Userinfo{Upload: 1, Download: 2, Total: 3}},
+		{" Upload = 1 ; DOWNLOAD=2 ", &Userinfo{Upload: 1, Download: 2}},
+		{"upload=1; download=abc; total=; expire", &Userinfo{Upload: 1}},
+		{"upload=1.5; total=1e9; other=5", &Userinfo{}},
+		{"upload=99999999999999999999", &Userinfo{}},
+		{"upload=-1", &Userinfo{Upload: -1}},
+		{";;;", &Userinfo{}},
+		{"total=10; total=20", &Userinfo{Total: 20}},
+	}
+	for _, tt := range tests {
+		if got := parseUserinfo(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseUserinfo(%q) = %+v, want %+v", tt.header, got, tt.want)
+		}
+	}
+}
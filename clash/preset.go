@@ -0,0 +1,99 @@
+package clash
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Dreamacro/clash/config"
+	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	PRESET_GENERAL = "general"
+	PRESET_DNS     = "dns"
+)
+
+// 预设的各层, 后面的覆盖前面的:
+// <kind>.yaml < subscribe/<name>.<kind>.yaml < 订阅中的 general 或 dns
+// 都不存在时返回 nil, 使用订阅中的配置
+func (s *Service) presetLayers(kind string, sub *Subscribe) (layers []map[string]any, err error) {
+	files := []string{s.pathResolve(kind + ".yaml")}
+	if sub != nil {
+		files = append(files, s.pathResolve(SUBSCRIBE_DIR, sub.Name+"."+kind+".yaml"))
+	}
+	for _, fn := range files {
+		var m map[string]any
+		if err = readYaml(fn, &m); err != nil {
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			return nil, fmt.Errorf("%s: %w", filepath.Base(fn), err)
+		}
+		if m != nil {
+			layers = append(layers, m)
+		}
+	}
+
+	if sub != nil {
+		switch {
+		case kind == PRESET_GENERAL && len(sub.General) > 0:
+			layers = append(layers, sub.General)
+		case kind == PRESET_DNS && len(sub.DNS) > 0:
+			//dns.yaml 的内容在 dns 下面, 订阅中直接写 dns 的字段
+			layers = append(layers, map[string]any{"dns": sub.DNS})
+		}
+	}
+	return
+}
+
+// 合并各层后由内核解析, 没有预设时返回 nil
+func (s *Service) presetConfig(kind string, sub *Subscribe) (cfg *config.Config, err error) {
+	layers, err := s.presetLayers(kind, sub)
+	if err != nil || len(layers) == 0 {
+		return
+	}
+
+	merged := map[string]any{}
+	for _, it := range layers {
+		mergeMap(merged, it)
+	}
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return
+	}
+	if cfg, err = executor.ParseWithBytes(data); err != nil {
+		err = fmt.Errorf("%s 预设: %w", kind, err)
+	}
+	return
+}
+
+// 深度合并, 映射按键合并, 其他值(包括列表)直接覆盖
+func mergeMap(dst, src map[string]any) {
+	for k, v := range src {
+		if sv, ok := v.(map[string]any); ok {
+			if dv, ok := dst[k].(map[string]any); ok {
+				mergeMap(dv, sv)
+				continue
+			}
+			//复制, 避免修改订阅中的配置
+			copied := map[string]any{}
+			mergeMap(copied, sv)
+			dst[k] = copied
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// 订阅是否有自己的 general 或 dns 预设
+func (s *Service) hasPreset(sub *Subscribe, kind string) bool {
+	if lo.Ternary(kind == PRESET_GENERAL, len(sub.General), len(sub.DNS)) > 0 {
+		return true
+	}
+	_, err := os.Stat(s.pathResolve(SUBSCRIBE_DIR, sub.Name+"."+kind+".yaml"))
+	return err == nil
+}
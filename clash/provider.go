@@ -0,0 +1,221 @@
+package clash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/go-chi/chi/v5"
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+const DEFAULT_PROVIDER_PREFETCH_TIMEOUT = 15 * time.Second //加载订阅前下载 proxy-providers 的总时间, 包括重试
+
+// 订阅中的 proxy-providers 和 rule-providers
+type ProviderInfo struct {
+	Name      string        `json:"name"`
+	Kind      string        `json:"kind"` //proxy 或 rule
+	Type      string        `json:"type"` //http 或 file
+	Url       string        `json:"url,omitempty"`
+	Path      string        `json:"path"` //相对于数据目录
+	Interval  time.Duration `json:"interval"`
+	Size      int64         `json:"size"`
+	Updated   time.Time     `json:"updated,omitempty"` //文件的修改时间, 零值表示还没有下载
+	Proxies   int           `json:"proxies,omitempty"` //运行中内核加载的节点数量
+	Supported bool          `json:"supported"`         //内核是否支持, 开源版内核不支持 rule-providers
+
+	url  string
+	path string
+}
+
+// 订阅文件中的 provider, name 为空时使用当前订阅
+func (s *Service) ProviderList(name string) (list []*ProviderInfo, err error) {
+	if name == "" {
+		name = s.Current()
+	}
+	sub := s.subscribeFind(name)
+	if sub == nil {
+		return nil, fmt.Errorf("订阅 [%s] 不存在", name)
+	}
+	return s.providers(s.pathResolve(SUBSCRIBE_DIR, sub.Name+".yaml"))
+}
+
+// 下载 http 类型的 provider, names 为空时下载全部; 运行中时重新加载当前订阅
+func (s *Service) ProviderUpdate(ctx context.Context, name string, names ...string) (err error) {
+	list, err := s.ProviderList(name)
+	if err != nil {
+		return
+	}
+	if len(names) > 0 {
+		for _, it := range names {
+			if !lo.ContainsBy(list, func(p *ProviderInfo) bool { return p.Name == it }) {
+				return fmt.Errorf("provider [%s] 不存在", it)
+			}
+		}
+		list = lo.Filter(list, func(p *ProviderInfo, _ int) bool { return lo.Contains(names, p.Name) })
+	}
+
+	var failed []string
+	for _, p := range list {
+		if p.Type != "http" || !p.Supported {
+			continue
+		}
+		if e := s.providerFetch(ctx, p); e != nil {
+			logs.Errorf("[provider] [%s] 下载失败: %v", p.Name, e)
+			failed = append(failed, p.Name)
+		}
+	}
+
+	s.mu.RLock()
+	running, current := s.clash != nil, s.config.Current
+	s.mu.RUnlock()
+	if running && (name == "" || nameEq(name)(&Subscribe{Name: current})) {
+		if e := s.subscribeSwitch(current); e != nil {
+			err = errors.Join(err, e)
+		}
+	}
+	if len(failed) > 0 {
+		err = errors.Join(err, errors.New("下载失败: "+strings.Join(failed, ", ")))
+	}
+	return
+}
+
+// 读取订阅文件中的 provider, 按名称排序
+func (s *Service) providers(fn string) (list []*ProviderInfo, err error) {
+	var raw struct {
+		ProxyProviders map[string]map[string]any `yaml:"proxy-providers"`
+		RuleProviders  map[string]map[string]any `yaml:"rule-providers"`
+	}
+	if err = readYaml(fn, &raw); err != nil {
+		return
+	}
+
+	add := func(kind string, m map[string]map[string]any) {
+		for name, it := range m {
+			p := &ProviderInfo{Name: name, Kind: kind, Supported: kind == "proxy"}
+			p.Type, _ = it["type"].(string)
+			p.url, _ = it["url"].(string)
+			p.Url = redactUrl(p.url)
+			if v, ok := it["interval"].(int); ok {
+				p.Interval = time.Duration(v) * time.Second
+			}
+			if path, _ := it["path"].(string); path != "" {
+				p.path = constant.Path.Resolve(path)
+				p.Path, _ = filepath.Rel(s.homeDir, p.path)
+			}
+			if stat, _ := os.Stat(p.path); stat != nil {
+				p.Size, p.Updated = stat.Size(), stat.ModTime()
+			}
+			list = append(list, p)
+		}
+	}
+	add("proxy", raw.ProxyProviders)
+	add("rule", raw.RuleProviders)
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Kind != list[j].Kind {
+			return list[i].Kind == "proxy"
+		}
+		return list[i].Name < list[j].Name
+	})
+	return
+}
+
+// 内核加载订阅前, 下载还没有下载或已过期的 proxy-providers, 内核直接读取本地文件
+// 失败或超时时只记录日志, 由内核自己下载; 切换订阅时持有 switchMu, 不能长时间等待
+func (s *Service) providerPrefetch(ctx context.Context, fn string) {
+	list, err := s.providers(fn)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, DEFAULT_PROVIDER_PREFETCH_TIMEOUT)
+	defer cancel()
+	for _, p := range list {
+		if p.Type != "http" || !p.Supported || p.url == "" || p.path == "" {
+			continue
+		}
+		if !p.Updated.IsZero() && (p.Interval <= 0 || time.Since(p.Updated) < p.Interval) {
+			continue
+		}
+		if err := s.providerFetch(ctx, p); err != nil {
+			logs.Warnf("[provider] [%s] 下载失败, 由内核下载: %v", p.Name, err)
+		}
+	}
+}
+
+// 使用与订阅相同的下载器, 检查后替换
+func (s *Service) providerFetch(ctx context.Context, p *ProviderInfo) (err error) {
+	if p.url == "" || p.path == "" {
+		return fmt.Errorf("url 或 path 为空")
+	}
+	if !constant.Path.IsSubPath(p.path) {
+		return fmt.Errorf("path 不在 %s 中", CLASH_DIR)
+	}
+
+	logs.Infof("[provider] [%s] 下载... %s", p.Name, p.Url)
+	temp := p.path + ".update"
+	defer os.Remove(temp)
+	if _, err = download(ctx, "", p.url, nil, "", temp); err != nil {
+		return
+	}
+
+	var raw struct {
+		Proxies []yaml.Node
+	}
+	if err = readYaml(temp, &raw); err != nil {
+		return
+	}
+	if len(raw.Proxies) == 0 {
+		return fmt.Errorf("没有节点")
+	}
+
+	if err = os.Rename(temp, p.path); err != nil {
+		return
+	}
+	if stat, _ := os.Stat(p.path); stat != nil {
+		p.Size, p.Updated = stat.Size(), stat.ModTime()
+	}
+	logs.Infof("[provider] [%s] 更新完成, 节点: %d", p.Name, len(raw.Proxies))
+	return
+}
+
+func (s *Service) apiProviders(w http.ResponseWriter, r *http.Request) {
+	list, err := s.ProviderList(r.URL.Query().Get("subscribe"))
+	if err != nil {
+		apiError(w, http.StatusNotFound, err)
+		return
+	}
+	s.mu.RLock()
+	running := tunnel.Providers()
+	s.mu.RUnlock()
+	for _, p := range list {
+		if it, ok := running[p.Name]; ok && p.Kind == "proxy" {
+			p.Proxies = len(it.Proxies())
+		}
+	}
+	apiJson(w, list)
+}
+
+// 强制更新当前订阅的 provider, 不指定名称时更新全部
+// 也可以用 ?name=a&name=b 指定多个, ?subscribe 指定订阅
+func (s *Service) apiProviderUpdate(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+	if name := chi.URLParam(r, "name"); name != "" {
+		names = append(names, name)
+	}
+	if err := s.ProviderUpdate(r.Context(), r.URL.Query().Get("subscribe"), names...); err != nil {
+		apiError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.apiProviders(w, r)
+}
@@ -0,0 +1,69 @@
+package clash
+
+import (
+	"net/http"
+	_ "unsafe"
+
+	_ "github.com/Dreamacro/clash/hub/route"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/cors"
+)
+
+// 内核 API 的路由, 内核只提供按地址监听且无法关闭的 route.Start, 这里直接使用它的路由
+
+//go:linkname routeHello github.com/Dreamacro/clash/hub/route.hello
+func routeHello(w http.ResponseWriter, r *http.Request)
+
+//go:linkname routeLogs github.com/Dreamacro/clash/hub/route.getLogs
+func routeLogs(w http.ResponseWriter, r *http.Request)
+
+//go:linkname routeTraffic github.com/Dreamacro/clash/hub/route.traffic
+func routeTraffic(w http.ResponseWriter, r *http.Request)
+
+//go:linkname routeVersion github.com/Dreamacro/clash/hub/route.version
+func routeVersion(w http.ResponseWriter, r *http.Request)
+
+//go:linkname configRouter github.com/Dreamacro/clash/hub/route.configRouter
+func configRouter() http.Handler
+
+//go:linkname inboundRouter github.com/Dreamacro/clash/hub/route.inboundRouter
+func inboundRouter() http.Handler
+
+//go:linkname proxyRouter github.com/Dreamacro/clash/hub/route.proxyRouter
+func proxyRouter() http.Handler
+
+//go:linkname ruleRouter github.com/Dreamacro/clash/hub/route.ruleRouter
+func ruleRouter() http.Handler
+
+//go:linkname connectionRouter github.com/Dreamacro/clash/hub/route.connectionRouter
+func connectionRouter() http.Handler
+
+//go:linkname proxyProviderRouter github.com/Dreamacro/clash/hub/route.proxyProviderRouter
+func proxyProviderRouter() http.Handler
+
+//go:linkname dnsRouter github.com/Dreamacro/clash/hub/route.dnsRouter
+func dnsRouter() http.Handler
+
+// 与 route.Start 相同的路由, 不验证口令, 由 hlash 验证
+func coreRouter() chi.Router {
+	r := chi.NewRouter()
+	r.Use(cors.New(cors.Options{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization"},
+		MaxAge:         300,
+	}).Handler)
+
+	r.Get("/", routeHello)
+	r.Get("/logs", routeLogs)
+	r.Get("/traffic", routeTraffic)
+	r.Get("/version", routeVersion)
+	r.Mount("/configs", configRouter())
+	r.Mount("/inbounds", inboundRouter())
+	r.Mount("/proxies", proxyRouter())
+	r.Mount("/rules", ruleRouter())
+	r.Mount("/connections", connectionRouter())
+	r.Mount("/providers/proxies", proxyProviderRouter())
+	r.Mount("/dns", dnsRouter())
+	return r
+}
@@ -0,0 +1,316 @@
+package clash
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/Dreamacro/clash/constant"
+	R "github.com/Dreamacro/clash/rule"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RULES_DIR    = "rules"
+	RULE_DIRECT  = "direct" //内置的直连列表
+	RULE_PROXY   = "proxy"  //内置的代理列表
+	POLICY_PROXY = "PROXY"  //订阅中名为 PROXY 的策略组, 没有时使用第一个 select 策略组
+)
+
+// 本地规则集, 文件为 rules/<name>.yaml, 格式与 rule-providers 相同(payload 列表)
+// 规则集展开后放在订阅的规则前面, 内核不支持 rule-providers
+type RuleSet struct {
+	Name     string
+	Behavior string //domain, ipcidr 或 classical, 默认 domain
+	Policy   string //匹配后使用的策略: DIRECT, REJECT, 策略组名称或 PROXY
+}
+
+// 规则集信息
+type RuleSetInfo struct {
+	Name     string `json:"name"`
+	Behavior string `json:"behavior"`
+	Policy   string `json:"policy"`
+	Path     string `json:"path"`
+	Entries  int    `json:"entries"`
+}
+
+// 配置的规则集, 没有配置 direct 和 proxy 时放在最前面
+func (s *Service) ruleSets() (list []*RuleSet) {
+	for _, it := range []*RuleSet{{Name: RULE_DIRECT, Policy: "DIRECT"}, {Name: RULE_PROXY, Policy: POLICY_PROXY}} {
+		if !lo.ContainsBy(s.config.Rules, func(r *RuleSet) bool { return r.Name == it.Name }) {
+			list = append(list, it)
+		}
+	}
+	return append(list, s.config.Rules...)
+}
+
+func (s *Service) ruleSet(name string) (rs *RuleSet, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rs, _ = lo.Find(s.ruleSets(), func(it *RuleSet) bool { return it.Name == name })
+	if rs == nil {
+		err = fmt.Errorf("规则集 [%s] 不存在", name)
+	}
+	return
+}
+
+// 规则集列表
+func (s *Service) RuleList() (list []RuleSetInfo) {
+	s.mu.RLock()
+	sets := s.ruleSets()
+	s.mu.RUnlock()
+	for _, rs := range sets {
+		payload, _ := s.rulePayload(rs.Name)
+		list = append(list, RuleSetInfo{
+			Name:     rs.Name,
+			Behavior: lo.Ternary(rs.Behavior != "", rs.Behavior, "domain"),
+			Policy:   rs.Policy,
+			Path:     RULES_DIR + "/" + rs.Name + ".yaml",
+			Entries:  len(payload),
+		})
+	}
+	return
+}
+
+// 规则集中的条目
+func (s *Service) RuleShow(name string) (payload []string, err error) {
+	if _, err = s.ruleSet(name); err != nil {
+		return
+	}
+	return s.rulePayload(name)
+}
+
+// 添加条目, domain 规则集中没有前缀的域名按后缀匹配(+.example.com), exact 为真时只匹配域名本身
+func (s *Service) RuleAdd(name string, exact bool, entries ...string) (err error) {
+	rs, err := s.ruleSet(name)
+	if err != nil {
+		return
+	}
+	for _, it := range entries {
+		if _, err = ruleParse(rs, it, "DIRECT"); err != nil {
+			return fmt.Errorf("%s: %w", it, err)
+		}
+	}
+	if rs.Behavior == "" || rs.Behavior == "domain" {
+		entries = lo.Map(entries, func(it string, _ int) string {
+			if exact || strings.HasPrefix(it, "+.") || strings.HasPrefix(it, ".") || strings.HasPrefix(it, "*.") {
+				return it
+			}
+			return "+." + it
+		})
+	}
+
+	return s.ruleEdit(name, func(payload *yaml.Node) {
+		for _, it := range entries {
+			if !lo.ContainsBy(payload.Content, func(n *yaml.Node) bool { return n.Value == it }) {
+				payload.Content = append(payload.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: it})
+			}
+		}
+	})
+}
+
+// 删除条目, 域名同时匹配 example.com 和 +.example.com
+func (s *Service) RuleRemove(name string, entries ...string) (err error) {
+	if _, err = s.ruleSet(name); err != nil {
+		return
+	}
+	return s.ruleEdit(name, func(payload *yaml.Node) {
+		payload.Content = lo.Reject(payload.Content, func(n *yaml.Node, _ int) bool {
+			return lo.Contains(entries, n.Value) || lo.Contains(entries, strings.TrimPrefix(n.Value, "+."))
+		})
+	})
+}
+
+// 修改规则集文件, 保留注释
+func (s *Service) ruleEdit(name string, edit func(payload *yaml.Node)) (err error) {
+	fn := s.pathResolve(RULES_DIR, name+".yaml")
+	data, err := os.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s 格式错误", fn)
+	}
+	payload := mappingGet(root, "payload")
+	if payload == nil || payload.Kind != yaml.SequenceNode {
+		mappingSet(root, "payload", []string{})
+		payload = mappingGet(root, "payload")
+	}
+	edit(payload)
+	//空列表编码为 [], 再添加时改回块格式
+	payload.Style = lo.Ternary(len(payload.Content) == 0, yaml.FlowStyle, 0)
+
+	if err = os.MkdirAll(s.pathResolve(RULES_DIR), 0755); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(&doc); err != nil {
+		return
+	}
+	enc.Close()
+	if err = os.WriteFile(fn+".tmp", buf.Bytes(), 0644); err != nil {
+		return
+	}
+	if err = os.Rename(fn+".tmp", fn); err != nil {
+		os.Remove(fn + ".tmp")
+	}
+	return
+}
+
+// 规则集文件中的 payload, 文件不存在时为空
+func (s *Service) rulePayload(name string) (payload []string, err error) {
+	var raw struct {
+		Payload []string
+	}
+	if err = readYaml(s.pathResolve(RULES_DIR, name+".yaml"), &raw); os.IsNotExist(err) {
+		return nil, nil
+	}
+	return raw.Payload, err
+}
+
+// 展开全部规则集, 出错的规则集跳过; 调用时需要持有 s.mu
+func (s *Service) localRules(proxies map[string]constant.Proxy) (rules []constant.Rule) {
+	for _, rs := range s.ruleSets() {
+		payload, err := s.rulePayload(rs.Name)
+		if err != nil {
+			logs.Warnf("[规则] [%s] 读取失败: %v", rs.Name, err)
+			continue
+		}
+		if len(payload) == 0 {
+			continue
+		}
+
+		target := rulePolicy(rs.Policy, proxies)
+		if target == "" {
+			logs.Warnf("[规则] [%s] 策略 [%s] 不存在, 跳过", rs.Name, rs.Policy)
+			continue
+		}
+		for _, it := range payload {
+			rule, err := ruleParse(rs, it, target)
+			if err != nil {
+				logs.Warnf("[规则] [%s] %s: %v", rs.Name, it, err)
+				continue
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return
+}
+
+// 重新加载规则集, 立即应用到运行中的内核
+func (s *Service) rulesReload() (count int) {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+
+	s.mu.RLock()
+	rules := s.localRules(tunnel.Proxies())
+	tunnel.UpdateRules(append(rules, s.subRules...))
+	s.mu.RUnlock()
+	logs.Infof("[规则] 重新加载, 本地规则: %d", len(rules))
+	return len(rules)
+}
+
+// 把规则集中的一条转换为内核的规则
+// domain: example.com(只匹配本身), +.example.com 或 .example.com(后缀), *.example.com 按后缀处理
+// ipcidr: 1.1.1.0/24, 不解析域名
+// classical: DOMAIN-KEYWORD,google 或 IP-CIDR,1.1.1.0/24,no-resolve
+func ruleParse(rs *RuleSet, entry string, target string) (constant.Rule, error) {
+	switch rs.Behavior {
+	case "", "domain":
+		if _, err := netip.ParsePrefix(entry); err == nil || net.ParseIP(entry) != nil {
+			return nil, fmt.Errorf("IP 请使用 ipcidr 类型的规则集")
+		}
+		for _, prefix := range []string{"+.", ".", "*."} {
+			if strings.HasPrefix(entry, prefix) {
+				return R.ParseRule(string(constant.RuleConfigDomainSuffix), strings.TrimPrefix(entry, prefix), target, nil)
+			}
+		}
+		return R.ParseRule(string(constant.RuleConfigDomain), entry, target, nil)
+	case "ipcidr":
+		return R.ParseRule(string(constant.RuleConfigIPCIDR), entry, target, []string{"no-resolve"})
+	case "classical":
+		parts := lo.Map(strings.Split(entry, ","), func(it string, _ int) string { return strings.TrimSpace(it) })
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("格式错误")
+		}
+		return R.ParseRule(parts[0], parts[1], target, parts[2:])
+	}
+	return nil, fmt.Errorf("不支持的类型 %s", rs.Behavior)
+}
+
+// 内核规则类型在配置文件中的写法
+var ruleConfigs = map[constant.RuleType]constant.RuleConfig{
+	constant.Domain:        constant.RuleConfigDomain,
+	constant.DomainSuffix:  constant.RuleConfigDomainSuffix,
+	constant.DomainKeyword: constant.RuleConfigDomainKeyword,
+	constant.GEOIP:         constant.RuleConfigGeoIP,
+	constant.IPCIDR:        constant.RuleConfigIPCIDR,
+	constant.SrcIPCIDR:     constant.RuleConfigSrcIPCIDR,
+	constant.SrcPort:       constant.RuleConfigSrcPort,
+	constant.DstPort:       constant.RuleConfigDstPort,
+	constant.InboundPort:   constant.RuleConfigInboundPort,
+	constant.Process:       constant.RuleConfigProcessName,
+	constant.ProcessPath:   constant.RuleConfigProcessPath,
+	constant.IPSet:         constant.RuleConfigIPSet,
+	constant.MATCH:         constant.RuleConfigMatch,
+}
+
+// 与配置文件中的写法相同, 如 DOMAIN-SUFFIX,example.com,DIRECT 或 IP-CIDR,1.1.1.0/24,PROXY,no-resolve
+func ruleLine(rule constant.Rule, _ int) string {
+	fields := []string{string(ruleConfigs[rule.RuleType()])}
+	if rule.RuleType() != constant.MATCH {
+		fields = append(fields, rule.Payload())
+	}
+	fields = append(fields, rule.Adapter())
+	switch rule.RuleType() {
+	case constant.GEOIP, constant.IPCIDR, constant.IPSet:
+		if !rule.ShouldResolveIP() {
+			fields = append(fields, "no-resolve")
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// 策略名称, PROXY 按订阅的策略组顺序选择第一个 select 策略组
+func rulePolicy(policy string, proxies map[string]constant.Proxy) string {
+	if _, ok := proxies[policy]; ok {
+		return policy
+	}
+	if policy != POLICY_PROXY {
+		return ""
+	}
+
+	for _, name := range globalNames(proxies) {
+		if p := proxies[name]; p != nil && p.Type() == constant.Selector {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s *Service) apiRules(w http.ResponseWriter, r *http.Request) {
+	apiJson(w, s.RuleList())
+}
+
+// 规则集文件修改后重新加载; config.yaml 中 rules 的修改需要 ctl reload
+func (s *Service) apiRulesReload(w http.ResponseWriter, r *http.Request) {
+	apiJson(w, map[string]int{"rules": s.rulesReload()})
+}
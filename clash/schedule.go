@@ -0,0 +1,95 @@
+package clash
+
+import (
+	"context"
+	"time"
+
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/robfig/cron/v3"
+	"github.com/samber/lo"
+)
+
+// 计划任务
+type task struct {
+	name     string
+	schedule cron.Schedule
+	next     *time.Time //下次执行的时间, 零值表示不再执行
+	run      func(ctx context.Context)
+}
+
+// 按当前配置重新开始计划任务, 先停止之前的计划, 正在执行的任务不会中断
+// 调用时需要持有 s.mu 的写锁
+func (s *Service) scheduleStart(ctx context.Context) {
+	if s.scheduleStop != nil {
+		s.scheduleStop()
+	}
+	s.scheduleStop = s.scheduleRun(ctx, lo.Flatten([][]*task{s.subscribeTasks(), s.dashboardTasks(), s.healthTasks()}))
+}
+
+// 关闭时停止计划, 之后重新加载配置不再开始计划, s.wg 不会再增加
+// 计划循环本身计入 s.wg, 循环中启动任务时计数不为零, 可以与 Wait 同时进行
+func (s *Service) scheduleHalt() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scheduleStop != nil {
+		s.scheduleStop()
+		s.scheduleStop = nil
+	}
+	s.runCtx = nil
+}
+
+// 按计划执行任务, stop 停止计划并等待退出; 执行中的任务使用 ctx, 不受 stop 影响
+func (s *Service) scheduleRun(ctx context.Context, tasks []*task) (stop func()) {
+	loopCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	stop = func() {
+		cancel()
+		<-done
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+
+		list := lo.Filter(tasks, func(it *task, _ int) bool { return it.schedule != nil })
+
+		lo.ForEach(list, func(it *task, _ int) {
+			if it.next.IsZero() {
+				*it.next = it.schedule.Next(time.Now())
+			}
+		})
+
+		for {
+			list = lo.Filter(list, func(it *task, _ int) bool { return !it.next.IsZero() })
+			if len(list) == 0 {
+				logs.Infof("[计划] 没有需要执行的任务")
+				return
+			}
+
+			nearly := lo.MinBy(list, func(a, b *task) bool { return a.next.Before(*b.next) })
+			sleep := -time.Since(*nearly.next)
+			if sleep < 0 {
+				sleep = time.Millisecond
+			}
+			logs.Infof("[计划] 最近需要执行: %s, 等待 %s", nearly.name, sleep)
+
+			select {
+			case <-loopCtx.Done():
+				logs.Infof("[计划] 退出")
+				return
+			case <-time.After(sleep):
+				if *nearly.next = nearly.schedule.Next(time.Now()); nearly.next.Before(time.Now()) {
+					*nearly.next = time.Time{}
+				}
+				logs.Infof("[计划] [%s] 执行", nearly.name)
+				s.wg.Add(1)
+				go func(it *task) {
+					defer s.wg.Done()
+					it.run(ctx)
+				}(nearly)
+			}
+		}
+	}()
+	return
+}
@@ -0,0 +1,31 @@
+package clash
+
+import (
+	"context"
+	"testing"
+)
+
+// 关闭开始后重新加载配置不再开始计划
+func TestScheduleHalt(t *testing.T) {
+	s := testService(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.mu.Lock()
+	s.runCtx = ctx
+	s.scheduleStart(ctx)
+	s.mu.Unlock()
+	if err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	s.scheduleHalt()
+	if err := s.load(); err != nil {
+		t.Fatal(err)
+	}
+	if s.scheduleStop != nil || s.runCtx != nil {
+		t.Error("关闭后不应重新开始计划")
+	}
+	s.wg.Wait()
+}
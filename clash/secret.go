@@ -0,0 +1,162 @@
+package clash
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SECRET_KEY_ENV = "HLASH_SECRET_KEY" //加密订阅列表使用的密钥
+	ENCRYPTED      = "enc:"             //加密后的订阅列表的前缀
+)
+
+// ${env:NAME} 或 ${file:path}
+var secretRef = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// 替换字符串中引用的环境变量和文件, 文件路径相对于数据目录, 内容去掉首尾空白
+func (s *Service) expandSecret(v string) (out string, err error) {
+	out = secretRef.ReplaceAllStringFunc(v, func(ref string) string {
+		m := secretRef.FindStringSubmatch(ref)
+		switch m[1] {
+		case "env":
+			val, ok := os.LookupEnv(m[2])
+			if !ok && err == nil {
+				err = fmt.Errorf("环境变量 %s 未设置", m[2])
+			}
+			return val
+		default:
+			fn := m[2]
+			if !filepath.IsAbs(fn) {
+				fn = s.pathResolve(fn)
+			}
+			data, e := os.ReadFile(fn)
+			if e != nil && err == nil {
+				err = fmt.Errorf("读取 %s 失败: %w", m[2], e)
+			}
+			return strings.TrimSpace(string(data))
+		}
+	})
+	return
+}
+
+// 隐藏错误中链接的路径和参数, 如 Get "https://example.com/sub?token=xxx": ...
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactUrl(urlErr.URL)
+	}
+	return err
+}
+
+// config.yaml 中的订阅列表已加密时解密, 返回是否加密
+func subscribeDecrypt(root *yaml.Node) (encrypted bool, err error) {
+	node := mappingGet(root, "subscribe")
+	if node == nil || node.Kind != yaml.ScalarNode || !strings.HasPrefix(node.Value, ENCRYPTED) {
+		return
+	}
+
+	data, err := secretOpen(strings.TrimPrefix(node.Value, ENCRYPTED))
+	if err != nil {
+		return
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	if len(doc.Content) > 0 {
+		*node = *doc.Content[0]
+	}
+	return true, nil
+}
+
+// 加密 config.yaml 中的订阅列表
+func subscribeEncrypt(root *yaml.Node) (err error) {
+	node := mappingGet(root, "subscribe")
+	if node == nil || node.Kind == yaml.ScalarNode {
+		return
+	}
+
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return
+	}
+	sealed, err := secretSeal(data)
+	if err != nil {
+		return
+	}
+	*node = yaml.Node{Kind: yaml.ScalarNode, Value: ENCRYPTED + sealed}
+	return
+}
+
+// 读取 config.yaml, 订阅列表已加密时解密
+func readConfig(fn string) (data []byte, err error) {
+	if data, err = os.ReadFile(fn); err != nil {
+		return
+	}
+	if !bytes.Contains(data, []byte(ENCRYPTED)) {
+		return
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return
+	}
+	if encrypted, e := subscribeDecrypt(doc.Content[0]); e != nil || !encrypted {
+		return data, e
+	}
+	return yaml.Marshal(&doc)
+}
+
+// AES-256-GCM, 密钥为环境变量 HLASH_SECRET_KEY 的 SHA256
+func secretAEAD() (aead cipher.AEAD, err error) {
+	key := os.Getenv(SECRET_KEY_ENV)
+	if key == "" {
+		return nil, fmt.Errorf("环境变量 %s 未设置", SECRET_KEY_ENV)
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+func secretSeal(plain []byte) (sealed string, err error) {
+	aead, err := secretAEAD()
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func secretOpen(sealed string) (plain []byte, err error) {
+	aead, err := secretAEAD()
+	if err != nil {
+		return
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(sealed))
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("订阅列表解密失败: 格式错误")
+	}
+	if plain, err = aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil); err != nil {
+		return nil, fmt.Errorf("订阅列表解密失败, 请检查 %s", SECRET_KEY_ENV)
+	}
+	return
+}
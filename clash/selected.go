@@ -0,0 +1,112 @@
+package clash
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+
+	"github.com/Dreamacro/clash/adapter"
+	"github.com/Dreamacro/clash/adapter/outboundgroup"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/go-chi/chi/v5"
+	"github.com/hxnas/hlash/pkg/logs"
+)
+
+const SELECTED_FN = "selected.json"
+
+// 记录通过 RESTful API 选择的节点, 订阅名称 => 策略组名称 => 节点名称
+type selected map[string]map[string]string
+
+func (s *Service) selectedRead() (sel selected) {
+	sel = selected{}
+	if data, err := os.ReadFile(s.pathResolve(SELECTED_FN)); err == nil {
+		json.Unmarshal(data, &sel)
+	}
+	return
+}
+
+func (s *Service) selectedWrite(sel selected) (err error) {
+	data, err := json.MarshalIndent(sel, "", "  ")
+	if err != nil {
+		return
+	}
+	fn := s.pathResolve(SELECTED_FN)
+	if err = os.WriteFile(fn+".tmp", data, 0644); err != nil {
+		return
+	}
+	return os.Rename(fn+".tmp", fn)
+}
+
+// 记录当前订阅中策略组选择的节点
+func (s *Service) selectedSave(group string) {
+	s.mu.RLock()
+	current, selector := s.config.Current, selectorOf(group)
+	s.mu.RUnlock()
+	if selector == nil {
+		return
+	}
+
+	s.selectedMu.Lock()
+	defer s.selectedMu.Unlock()
+
+	sel := s.selectedRead()
+	if sel[current] == nil {
+		sel[current] = map[string]string{}
+	}
+	sel[current][group] = selector.Now()
+	if err := s.selectedWrite(sel); err != nil {
+		logs.Warnf("[选择] 保存失败: %v", err)
+	}
+}
+
+// 应用配置后恢复订阅中策略组选择的节点, 节点或策略组不存在时跳过, 保留记录以便订阅更新后恢复
+// 调用时需要持有 s.mu 的写锁
+func (s *Service) selectedRestore(name string) {
+	s.selectedMu.Lock()
+	defer s.selectedMu.Unlock()
+
+	for group, proxy := range s.selectedRead()[name] {
+		selector := selectorOf(group)
+		if selector == nil {
+			logs.Debugf("[选择] [%s] 策略组 [%s] 不存在, 跳过", name, group)
+			continue
+		}
+		if err := selector.Set(proxy); err != nil {
+			logs.Warnf("[选择] [%s] 策略组 [%s] 中没有 [%s], 使用 [%s]", name, group, proxy, selector.Now())
+			continue
+		}
+		logs.Debugf("[选择] [%s] 恢复 %s => %s", name, group, proxy)
+	}
+}
+
+// 转发到内核的 PUT /proxies/{name}, 成功后记录选择的节点
+func (s *Service) selectedHandler(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+		if rw.status != http.StatusNoContent {
+			return
+		}
+		s.selectedSave(chi.URLParam(r, "name"))
+	}
+}
+
+// 运行中内核的 select 策略组, 不存在或不是 select 时返回 nil
+func selectorOf(group string) *outboundgroup.Selector {
+	proxy, ok := tunnel.Proxies()[group].(*adapter.Proxy)
+	if !ok {
+		return nil
+	}
+	selector, _ := proxy.ProxyAdapter.(*outboundgroup.Selector)
+	return selector
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
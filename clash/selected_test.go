@@ -0,0 +1,57 @@
+package clash
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// 通过 API 选择的节点按订阅记录, 切换订阅后恢复; 策略组名称只解码一次
+func TestSelectedRestore(t *testing.T) {
+	const group = "100% 香港"
+	s := testService(t)
+	sub := strings.ReplaceAll(testSubscribe, "PROXY", group)
+	if err := os.WriteFile(filepath.Join(s.homeDir, SUBSCRIBE_DIR, "a.yaml"), []byte(sub), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.subscribeSwitch("a"); err != nil {
+		t.Fatal(err)
+	}
+	if s.clash.Profile.StoreSelected {
+		t.Error("内核的 store-selected 应该关闭")
+	}
+
+	//代替内核的 PUT /proxies/{name}
+	router := chi.NewRouter()
+	router.Put("/proxies/{name}", s.selectedHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		err := selectorOf(group).Set("JP 01")
+		s.mu.RUnlock()
+		if err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/proxies/"+url.PathEscape(group), nil))
+
+	if got := s.selectedRead()["a"][group]; got != "JP 01" {
+		t.Fatalf("selected.json: %q", got)
+	}
+
+	for _, name := range []string{"b", "a"} {
+		if err := s.subscribeSwitch(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if now := selectorOf(group).Now(); now != "JP 01" {
+		t.Errorf("切换回 a 后: %q", now)
+	}
+}
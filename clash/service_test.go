@@ -0,0 +1,78 @@
+package clash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Dreamacro/clash/constant"
+)
+
+const testSubscribe = `proxies:
+  - {name: "HK 01", type: socks5, server: 127.0.0.1, port: 1080}
+  - {name: "JP 01", type: socks5, server: 127.0.0.1, port: 1081}
+proxy-groups:
+  - {name: PROXY, type: select, proxies: ["HK 01", "JP 01"]}
+rules:
+  - MATCH,PROXY
+`
+
+// 数据目录中有两个已下载的订阅 a 和 b
+func testService(t *testing.T) *Service {
+	home := t.TempDir()
+	files := map[string]string{
+		CONFIG_FN: `current: a
+health:
+  interval: 1h
+subscribe:
+  - name: a
+    url: file:subscribe/a.yaml
+    cron: "@every 1h"
+  - name: b
+    url: file:subscribe/b.yaml
+    cron: "@every 1h"
+`,
+		"general.yaml":              "mode: rule\nlog-level: silent\n",
+		SUBSCRIBE_DIR + "/a.yaml":   testSubscribe,
+		SUBSCRIBE_DIR + "/b.yaml":   testSubscribe,
+		RULES_DIR + "/direct.yaml":  "payload:\n  - +.example.com\n",
+		CLASH_DIR + "/.placeholder": "",
+	}
+	for name, data := range files {
+		fn := filepath.Join(home, name)
+		os.MkdirAll(filepath.Dir(fn), 0755)
+		if err := os.WriteFile(fn, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New(home)
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// 生成的 url-test 策略组创建后在后台测试节点, 会读取内核的全局变量
+// 等待全部测试结束, 以免与后面的测试中的 ApplyConfig 冲突
+func waitHealthCheck(t *testing.T, s *Service) {
+	expected := map[string]int{}
+	for _, it := range s.groups {
+		if it["type"] == "url-test" && s.clash.Proxies[it["name"].(string)].Type() == constant.URLTest {
+			for _, name := range it["proxies"].([]string) {
+				expected[name]++
+			}
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for name, count := range expected {
+		for len(s.clash.Proxies[name].DelayHistory()) < count {
+			if time.Now().After(deadline) {
+				t.Fatalf("等待节点 [%s] 的测试超时", name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
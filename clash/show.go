@@ -0,0 +1,332 @@
+package clash
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Dreamacro/clash/component/auth"
+	"github.com/Dreamacro/clash/config"
+	"github.com/Dreamacro/clash/dns"
+	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/Dreamacro/clash/log"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+const REDACTED = "******"
+
+// 需要隐藏值的字段
+var secretKeys = []string{"secret", "password", "passwd", "uuid", "psk", "auth-str", "obfs-password", "private-key", "token"}
+
+// 合并后生效的配置(YAML), secret, 节点的密码和链接中的路径和参数会被隐藏
+// diff 为真时输出与订阅文件的差异
+func (s *Service) ConfigShow(diff bool) (out []byte, err error) {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := s.subscribeNode()
+	if err != nil {
+		return
+	}
+
+	//未运行时与 check 一样按 clashRun 的方式合并, 生成的策略组和本地规则集需要解析节点
+	if s.clash == nil {
+		log.SetLevel(log.SILENT)
+		if s.clash, err = executor.ParseWithPath(s.pathResolve(SUBSCRIBE_DIR, s.config.Current+".yaml")); err != nil {
+			return
+		}
+		s.loadGeneral(s.config.Current)
+		s.clashOverride(true)
+	}
+
+	effective := s.effectiveNode(raw)
+	if out, err = yamlEncode(redact(effective)); err != nil || !diff {
+		return
+	}
+
+	//重新读取订阅文件, 以相同的格式输出后比较
+	if raw, err = s.subscribeNode(); err != nil {
+		return
+	}
+	before, err := yamlEncode(redact(raw))
+	if err != nil {
+		return
+	}
+
+	text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(before)),
+		B:        difflib.SplitLines(string(out)),
+		FromFile: SUBSCRIBE_DIR + "/" + s.config.Current + ".yaml",
+		ToFile:   "effective",
+		Context:  3,
+	})
+	out = []byte(text)
+	return
+}
+
+// 输出合并后生效的配置, ?diff 时输出差异
+func (s *Service) configHandler(w http.ResponseWriter, r *http.Request) {
+	out, err := s.ConfigShow(r.URL.Query().Has("diff"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	w.Write(out)
+}
+
+// 当前订阅文件的 YAML 文档
+func (s *Service) subscribeNode() (node *yaml.Node, err error) {
+	data, err := os.ReadFile(s.pathResolve(SUBSCRIBE_DIR, s.config.Current+".yaml"))
+	if err != nil {
+		return
+	}
+
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return &yaml.Node{Kind: yaml.MappingNode}, nil
+	}
+	return doc.Content[0], nil
+}
+
+// 用生效的通用配置和DNS替换订阅中的对应字段, 其他字段保持原来的顺序
+// 生成的策略组放在订阅的策略组前面, 规则包括本地规则集
+func (s *Service) effectiveNode(raw *yaml.Node) *yaml.Node {
+	general := generalPairs(s.clash.General)
+	replaced := lo.Map(general, func(it pair, _ int) string { return it.key })
+	replaced = append(replaced, "dns", "interface-name", "routing-mark", "authentication")
+
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, it := range general {
+		mappingSet(node, it.key, it.value)
+	}
+	if len(s.clash.Users) > 0 {
+		mappingSet(node, "authentication", lo.Map(s.clash.Users, func(it auth.AuthUser, _ int) string { return it.User + ":" + it.Pass }))
+	}
+	if s.clash.DNS != nil {
+		mappingSet(node, "dns", dnsPairs(s.clash.DNS))
+	}
+
+	for i := 0; i+1 < len(raw.Content); i += 2 {
+		if !lo.Contains(replaced, raw.Content[i].Value) {
+			node.Content = append(node.Content, raw.Content[i], raw.Content[i+1])
+		}
+	}
+
+	if len(s.groups) > 0 {
+		groups := lo.Map(s.groups, func(it map[string]any, _ int) any { return groupPairs(it) })
+		if list := mappingGet(raw, "proxy-groups"); list != nil && list.Kind == yaml.SequenceNode {
+			groups = append(groups, lo.ToAnySlice(list.Content)...)
+		}
+		mappingSet(node, "proxy-groups", groups)
+	}
+	mappingSet(node, "rules", lo.Map(s.clash.Rules, ruleLine))
+	return node
+}
+
+// 生成的策略组, 按配置文件中常见的顺序输出
+func groupPairs(mapping map[string]any) (p pairs) {
+	for _, key := range []string{"name", "type", "proxies", "use", "filter", "url", "interval"} {
+		if value, ok := mapping[key]; ok {
+			p = append(p, pair{key, value})
+		}
+	}
+	return
+}
+
+type pair struct {
+	key   string
+	value any
+}
+
+// 有序的键值, 编码为 YAML 时保持顺序
+type pairs []pair
+
+func (p pairs) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, it := range p {
+		mappingSet(node, it.key, it.value)
+	}
+	return node, nil
+}
+
+func generalPairs(general *config.General) pairs {
+	p := pairs{
+		{"port", general.Port},
+		{"socks-port", general.SocksPort},
+		{"redir-port", general.RedirPort},
+		{"tproxy-port", general.TProxyPort},
+		{"mixed-port", general.MixedPort},
+		{"allow-lan", general.AllowLan},
+		{"bind-address", general.BindAddress},
+		{"mode", general.Mode.String()},
+		{"log-level", general.LogLevel.String()},
+		{"ipv6", general.IPv6},
+		{"external-controller", general.ExternalController},
+		{"external-ui", general.ExternalUI},
+		{"secret", general.Secret},
+	}
+	if general.Interface != "" {
+		p = append(p, pair{"interface-name", general.Interface})
+	}
+	if general.RoutingMark != 0 {
+		p = append(p, pair{"routing-mark", general.RoutingMark})
+	}
+	return p
+}
+
+func dnsPairs(d *config.DNS) pairs {
+	p := pairs{
+		{"enable", d.Enable},
+		{"ipv6", d.IPv6},
+		{"listen", d.Listen},
+		{"enhanced-mode", d.EnhancedMode.String()},
+	}
+	if d.FakeIPRange != nil {
+		p = append(p, pair{"fake-ip-range", d.FakeIPRange.IPNet().String()})
+	}
+	if len(d.DefaultNameserver) > 0 {
+		p = append(p, pair{"default-nameserver", lo.Map(d.DefaultNameserver, nameServerString)})
+	}
+	p = append(p, pair{"nameserver", lo.Map(d.NameServer, nameServerString)})
+	if len(d.Fallback) > 0 {
+		p = append(p, pair{"fallback", lo.Map(d.Fallback, nameServerString)})
+		p = append(p, pair{"fallback-filter", pairs{
+			{"geoip", d.FallbackFilter.GeoIP},
+			{"geoip-code", d.FallbackFilter.GeoIPCode},
+			{"ipcidr", lo.Map(d.FallbackFilter.IPCIDR, func(it *net.IPNet, _ int) string { return it.String() })},
+			{"domain", d.FallbackFilter.Domain},
+		}})
+	}
+	if len(d.NameServerPolicy) > 0 {
+		domains := lo.Keys(d.NameServerPolicy)
+		sort.Strings(domains)
+		policy := pairs{}
+		for _, domain := range domains {
+			policy = append(policy, pair{domain, nameServerString(d.NameServerPolicy[domain], 0)})
+		}
+		p = append(p, pair{"nameserver-policy", policy})
+	}
+	if len(d.SearchDomains) > 0 {
+		p = append(p, pair{"search-domains", d.SearchDomains})
+	}
+	return p
+}
+
+// 与配置文件中的写法相同, 如 tls://1.1.1.1:853
+func nameServerString(ns dns.NameServer, _ int) (s string) {
+	switch ns.Net {
+	case "":
+		s = ns.Addr
+	case "tcp-tls":
+		s = "tls://" + ns.Addr
+	case "https":
+		s = ns.Addr
+	default:
+		s = ns.Net + "://" + ns.Addr
+	}
+	if ns.Interface != "" {
+		s += "#" + ns.Interface
+	}
+	return
+}
+
+// 隐藏 secret, 密码和链接中的参数, 返回修改后的副本
+func redact(node *yaml.Node) *yaml.Node {
+	if node == nil {
+		return nil
+	}
+	n := *node
+	n.Content = make([]*yaml.Node, len(node.Content))
+	for i, it := range node.Content {
+		n.Content[i] = redact(it)
+	}
+
+	if n.Kind != yaml.MappingNode {
+		return &n
+	}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := strings.ToLower(n.Content[i].Value), n.Content[i+1]
+		switch {
+		case key == "authentication" && value.Kind == yaml.SequenceNode:
+			//user:pass
+			for _, it := range value.Content {
+				if user, _, ok := strings.Cut(it.Value, ":"); ok {
+					it.Value = user + ":" + REDACTED
+				}
+			}
+		case value.Kind != yaml.ScalarNode || value.Value == "":
+		case lo.Contains(secretKeys, key):
+			value.Value, value.Tag, value.Style = REDACTED, "!!str", 0
+		case key == "url":
+			value.Value = redactUrl(value.Value)
+		}
+	}
+	return &n
+}
+
+// 隐藏链接中的用户信息, 路径中第一段之后的部分和参数值, 订阅的 token 一般在这里
+func redactUrl(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+
+	//在原文上替换, 重新编码会把 ${NAME} 这样的引用转义
+	rest, fragment, hasFragment := strings.Cut(s, "#")
+	s, query, hasQuery := strings.Cut(rest, "?")
+	scheme, authority, _ := strings.Cut(s, "//")
+	path := ""
+	if i := strings.Index(authority, "/"); i >= 0 {
+		authority, path = authority[:i], authority[i:]
+	}
+	if u.User != nil {
+		authority = REDACTED + "@" + authority[strings.LastIndex(authority, "@")+1:]
+	}
+	//如 /api/v1/client/subscribe/<token>, /link/<token>
+	segments := strings.Split(path, "/")
+	for i := 2; i < len(segments); i++ {
+		if segments[i] != "" {
+			segments[i] = REDACTED
+		}
+	}
+	s = scheme + "//" + authority + strings.Join(segments, "/")
+	if hasQuery {
+		s += "?"
+	}
+	if query != "" {
+		keys := lo.Uniq(lo.Map(strings.Split(query, "&"), func(it string, _ int) string {
+			k, _, _ := strings.Cut(it, "=")
+			return k + "=" + REDACTED
+		}))
+		s += strings.Join(keys, "&")
+	}
+	if hasFragment {
+		s += "#" + fragment
+	}
+	return s
+}
+
+func yamlEncode(node *yaml.Node) (data []byte, err error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err = enc.Encode(node); err != nil {
+		return
+	}
+	err = enc.Close()
+	data = buf.Bytes()
+	return
+}
@@ -0,0 +1,71 @@
+package clash
+
+import (
+	"context"
+	"time"
+
+	"github.com/Dreamacro/clash/dns"
+	"github.com/Dreamacro/clash/listener"
+	"github.com/Dreamacro/clash/tunnel"
+	"github.com/Dreamacro/clash/tunnel/statistic"
+	"github.com/hxnas/hlash/pkg/logs"
+)
+
+const DEFAULT_DRAIN = 5 * time.Second //关闭时等待活动连接结束的默认时间
+
+// 按顺序关闭: 计划任务和下载, 入站监听, 活动连接, RESTful API, 透明代理规则
+func (s *Service) shutdown() {
+	logs.Infof("[关闭] 等待计划任务和下载退出...")
+	s.scheduleHalt()
+	s.wg.Wait()
+
+	logs.Infof("[关闭] 关闭入站监听...")
+	listener.ReCreatePortsListeners(listener.Ports{}, tunnel.TCPIn(), tunnel.UDPIn())
+	listener.ReCreateListeners(nil, tunnel.TCPIn(), tunnel.UDPIn())
+	listener.PatchTunnel(nil, tunnel.TCPIn(), tunnel.UDPIn())
+	dns.ReCreateServer("", nil, nil)
+
+	s.mu.RLock()
+	drain, transparent := s.config.Drain, s.config.Transparent.Enable
+	s.mu.RUnlock()
+	if drain <= 0 {
+		drain = DEFAULT_DRAIN
+	}
+	s.drain(drain)
+
+	if s.ctrl != nil {
+		logs.Infof("[关闭] 关闭 RESTful API...")
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		s.ctrl.shutdown(ctx)
+		cancel()
+	}
+
+	if transparent {
+		logs.Infof("[关闭] 清除透明代理规则...")
+		s.backward()
+	}
+
+	logs.Infof("[关闭] 完成")
+}
+
+// 等待活动连接结束, 超时后强制关闭
+func (s *Service) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		connections := statistic.DefaultManager.Snapshot().Connections
+		if len(connections) == 0 {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			logs.Infof("[关闭] 强制关闭 %d 个活动连接", len(connections))
+			for _, c := range connections {
+				c.Close()
+			}
+			return
+		}
+
+		logs.Infof("[关闭] 等待 %d 个活动连接结束...", len(connections))
+		time.Sleep(min(time.Second, time.Until(deadline)+time.Millisecond))
+	}
+}
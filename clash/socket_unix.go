@@ -0,0 +1,34 @@
+//go:build !windows
+
+package clash
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// 在只有当前用户可以访问的临时目录中创建 socket, 设置权限后移动到目标位置
+// 避免按默认的 umask 创建后到修改权限前被其他用户连接
+func listenUnix(socket string) (l net.Listener, err error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socket), ".hlash-")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	temp := filepath.Join(dir, filepath.Base(socket))
+	if l, err = net.Listen("unix", temp); err != nil {
+		return
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err = os.Chmod(temp, 0600); err == nil {
+		err = os.Rename(temp, socket)
+	}
+	if err != nil {
+		l.Close()
+		l = nil
+	}
+	return
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package clash
+
+import "net"
+
+// Windows 的 Unix socket 按文件的 ACL 控制访问, 继承数据目录的权限
+func listenUnix(socket string) (net.Listener, error) {
+	return net.Listen("unix", socket)
+}
@@ -0,0 +1,168 @@
+package clash
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hxnas/hlash/pkg/logs"
+)
+
+const DEFAULT_EXEC_TIMEOUT = time.Minute
+
+// 从本地 git 仓库读取订阅文件
+type SubscribeGit struct {
+	Repo string //仓库目录, 相对于数据目录
+	Path string //文件在仓库中的路径
+	Ref  string //分支, 标签或提交, 默认 HEAD
+	Pull bool   //读取前先执行 git pull --ff-only, 失败时使用本地已有的版本
+}
+
+// 是否设置了更新来源: url, url-file, exec 或 git
+func (sub *Subscribe) hasSource() bool {
+	return sub.Url != "" || sub.UrlFile != "" || len(sub.Exec) > 0 || sub.Git != nil
+}
+
+// 日志中显示的更新来源, 链接的路径和参数会被隐藏
+func (sub *Subscribe) source() string {
+	switch {
+	case len(sub.Exec) > 0:
+		return "exec: " + sub.Exec[0]
+	case sub.Git != nil:
+		return "git: " + sub.Git.Repo + ":" + sub.Git.Path
+	case sub.UrlFile != "":
+		return sub.UrlFile
+	default:
+		return redactUrl(sub.Url)
+	}
+}
+
+// 按订阅的来源获取文件, 保存到 saveTo; 只有 HTTP(S) 有响应头
+func (s *Service) fetch(ctx context.Context, sub *Subscribe, saveTo string) (header http.Header, err error) {
+	switch {
+	case len(sub.Exec) > 0:
+		return nil, s.fetchExec(ctx, sub, saveTo)
+	case sub.Git != nil:
+		return nil, s.fetchGit(ctx, sub, saveTo)
+	}
+
+	link, headers, body, err := s.subscribeRequest(ctx, sub)
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(link, "file:") {
+		return nil, s.fetchFile(link, saveTo)
+	}
+	return download(ctx, sub.Method, link, headers, body, saveTo)
+}
+
+// file:///abs/path 或 file:rel/path(相对于数据目录)
+func (s *Service) fetchFile(link string, saveTo string) (err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return
+	}
+	fn := u.Path
+	if u.Opaque != "" {
+		fn = u.Opaque
+	}
+	if !filepath.IsAbs(fn) {
+		fn = s.pathResolve(fn)
+	}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	return readToFile(f, saveTo, true)
+}
+
+// 执行命令, 使用标准输出; 工作目录为数据目录, 参数支持模板和引用
+func (s *Service) fetchExec(ctx context.Context, sub *Subscribe, saveTo string) (err error) {
+	data := s.templateData(sub)
+	args := make([]string, len(sub.Exec))
+	for i, it := range sub.Exec {
+		if args[i], err = s.render(it, data); err != nil {
+			return
+		}
+	}
+
+	timeout := sub.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_EXEC_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = s.homeDir
+	cmd.Env = append(os.Environ(), "HLASH_HOME="+s.homeDir, "HLASH_SUBSCRIBE="+sub.Name)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err = cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, lastLine(msg))
+		}
+		return
+	}
+	if stdout.Len() == 0 {
+		return fmt.Errorf("命令没有输出")
+	}
+	return readToFile(&stdout, saveTo, true)
+}
+
+// git show <ref>:<path>, 不修改工作区
+func (s *Service) fetchGit(ctx context.Context, sub *Subscribe, saveTo string) (err error) {
+	g := sub.Git
+	if g.Repo == "" || g.Path == "" {
+		return fmt.Errorf("git.repo 和 git.path 不能为空")
+	}
+	repo := g.Repo
+	if !filepath.IsAbs(repo) {
+		repo = s.pathResolve(repo)
+	}
+
+	git := func(args ...string) (out []byte, err error) {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repo}, args...)...)
+		cmd.Stderr = &stderr
+		if out, err = cmd.Output(); err != nil {
+			//git 的第一行是错误原因, 后面是提示
+			if msg, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n"); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+		}
+		return
+	}
+
+	if g.Pull {
+		if _, err := git("pull", "--ff-only", "--quiet"); err != nil {
+			logs.Warnf("[订阅] [%s] git pull 失败, 使用本地版本: %v", sub.Name, err)
+		}
+	}
+
+	ref := g.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	out, err := git("show", ref+":"+filepath.ToSlash(g.Path))
+	if err != nil {
+		return
+	}
+	return readToFile(bytes.NewReader(out), saveTo, true)
+}
+
+func lastLine(s string) string {
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
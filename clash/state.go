@@ -0,0 +1,166 @@
+package clash
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const STATE_FN = "state.json"
+
+// 运行状态, 运行中的实例写入数据目录, 供 svc status 等命令读取
+type State struct {
+	Pid       int                        `json:"pid"`
+	Started   time.Time                  `json:"started"`
+	Current   string                     `json:"current"`
+	Subscribe map[string]*SubscribeState `json:"subscribe,omitempty"`
+	Failover  *Failover                  `json:"failover,omitempty"` //最后一次故障转移
+}
+
+// 健康检查触发的订阅切换
+type Failover struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Reason string    `json:"reason"`
+}
+
+// 订阅最后一次更新的结果
+type SubscribeState struct {
+	Time        time.Time     `json:"time"`
+	Success     bool          `json:"success"`
+	Error       string        `json:"error,omitempty"`
+	LastSuccess time.Time     `json:"lastSuccess"`
+	LastFailure time.Time     `json:"lastFailure"`
+	Duration    time.Duration `json:"duration"`           //下载耗时
+	Proxies     int           `json:"proxies"`            //节点数量, 不含策略组
+	Userinfo    *Userinfo     `json:"userinfo,omitempty"` //订阅响应头 subscription-userinfo 中的流量信息
+}
+
+// 读取数据目录中的运行状态
+func ReadState(homeDir string) (state *State, err error) {
+	data, err := os.ReadFile(filepath.Join(homeDir, STATE_FN))
+	if err != nil {
+		return
+	}
+	state = &State{}
+	err = json.Unmarshal(data, state)
+	return
+}
+
+// 进程是否还在运行
+func (st *State) Alive() bool {
+	return st != nil && st.Pid > 0 && processAlive(st.Pid)
+}
+
+// 启动时重置运行状态, 保留之前的订阅更新结果
+func (s *Service) stateStart() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if old, _ := ReadState(s.homeDir); old != nil {
+		s.state.Subscribe = old.Subscribe
+	}
+	if s.state.Subscribe == nil {
+		s.state.Subscribe = map[string]*SubscribeState{}
+	}
+	s.state.Pid = os.Getpid()
+	s.state.Started = time.Now()
+	s.state.Current = s.Current()
+	s.stateSave()
+}
+
+// 记录订阅更新的结果, 失败时保留上次成功的节点数量和流量信息
+func (s *Service) stateSubscribe(name string, st *SubscribeState, err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	//只有运行中的实例写入状态, 命令行更新时有实例在运行则不记录, 否则在磁盘上的状态基础上修改
+	state := &s.state
+	if s.state.Pid != os.Getpid() {
+		disk, _ := ReadState(s.homeDir)
+		if disk.Alive() {
+			return
+		}
+		if disk == nil {
+			disk = &State{}
+		}
+		state = disk
+	}
+	if state.Subscribe == nil {
+		state.Subscribe = map[string]*SubscribeState{}
+	}
+	st.Time = time.Now()
+	st.Success = err == nil
+	if old := state.Subscribe[name]; old != nil {
+		st.LastSuccess, st.LastFailure = old.LastSuccess, old.LastFailure
+		if err != nil {
+			st.Proxies, st.Userinfo = old.Proxies, old.Userinfo
+		}
+	}
+	if err != nil {
+		st.Error = err.Error()
+		st.LastFailure = st.Time
+	} else {
+		st.LastSuccess = st.Time
+	}
+	state.Subscribe[name] = st
+	s.stateWrite(state)
+}
+
+// 记录切换后的当前订阅
+func (s *Service) stateCurrent(name string) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.state.Current = name
+	s.stateSave()
+}
+
+// 记录故障转移
+func (s *Service) stateFailover(failover *Failover) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.state.Failover = failover
+	s.stateSave()
+}
+
+// 退出时清除进程号
+func (s *Service) stateStop() {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.state.Pid = 0
+	s.stateSave()
+}
+
+// 运行状态的副本
+func (s *Service) stateSnapshot() (state State) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	state = s.state
+	state.Subscribe = make(map[string]*SubscribeState, len(s.state.Subscribe))
+	for name, it := range s.state.Subscribe {
+		st := *it
+		state.Subscribe[name] = &st
+	}
+	return
+}
+
+func (s *Service) stateSave() {
+	s.stateWrite(&s.state)
+}
+
+func (s *Service) stateWrite(state *State) {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return
+	}
+	fn := s.pathResolve(STATE_FN)
+	if err = os.WriteFile(fn+".tmp", data, 0644); err == nil {
+		os.Rename(fn+".tmp", fn)
+	}
+}
@@ -0,0 +1,208 @@
+package clash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/samber/lo"
+	"gopkg.in/yaml.v3"
+)
+
+// 订阅信息
+type SubscribeInfo struct {
+	Name    string
+	Url     string //更新来源, 链接的路径和参数已隐藏
+	Cron    string
+	Current bool
+	Updated time.Time //订阅文件的修改时间, 零值表示还没有下载
+	Next    time.Time //按更新计划的下次更新时间
+	Proxies int
+}
+
+// 添加订阅, 名称为空时使用链接的文件名
+func (s *Service) SubscribeAdd(sub *Subscribe) (err error) {
+	if sub.Url == "" {
+		return fmt.Errorf("链接为空")
+	}
+	if sub.Name == "" {
+		if subscribeNeedsName(sub.Url) {
+			return fmt.Errorf("链接中引用了环境变量, 文件或模板, 请指定名称")
+		}
+		sub.Name = subscribeDefaultName(sub.Url)
+	}
+	if s.subscribeFind(sub.Name) != nil {
+		return fmt.Errorf("订阅 [%s] 已存在", sub.Name)
+	}
+	if sub.Cron != "" {
+		if _, err = cron.ParseStandard(sub.Cron); err != nil {
+			return fmt.Errorf("更新计划 %q 错误: %w", sub.Cron, err)
+		}
+	}
+
+	item := pairs{{"name", sub.Name}, {"url", sub.Url}}
+	if sub.Cron != "" {
+		item = append(item, pair{"cron", sub.Cron})
+	}
+	if sub.Method != "" {
+		item = append(item, pair{"method", sub.Method})
+	}
+	if len(sub.Headers) > 0 {
+		item = append(item, pair{"headers", sub.Headers})
+	}
+	if sub.Body != "" {
+		item = append(item, pair{"body", sub.Body})
+	}
+
+	return s.configEdit(func(root *yaml.Node) (err error) {
+		var node yaml.Node
+		if err = node.Encode(item); err != nil {
+			return
+		}
+		list := mappingGet(root, "subscribe")
+		if list == nil || list.Kind != yaml.SequenceNode {
+			mappingSet(root, "subscribe", []any{})
+			list = mappingGet(root, "subscribe")
+		}
+		//subscribe: [] 添加后改为块格式
+		list.Style &^= yaml.FlowStyle
+		list.Content = append(list.Content, &node)
+		return
+	})
+}
+
+// 加密或解密 config.yaml 中的订阅列表, 密钥为环境变量 HLASH_SECRET_KEY
+func (s *Service) SubscribeEncrypt(encrypt bool) (err error) {
+	return s.configEditEncrypted(func(root *yaml.Node, encrypted *bool) error {
+		if mappingGet(root, "subscribe") == nil {
+			return fmt.Errorf("订阅列表为空")
+		}
+		*encrypted = encrypt
+		return nil
+	})
+}
+
+// 删除订阅, 不删除已下载的文件; 删除的是当前订阅时, 使用第一个订阅
+func (s *Service) SubscribeRemove(name string) (err error) {
+	sub := s.subscribeFind(name)
+	if sub == nil {
+		return fmt.Errorf("订阅 [%s] 不存在", name)
+	}
+
+	return s.configEdit(func(root *yaml.Node) (err error) {
+		list := mappingGet(root, "subscribe")
+		if list == nil {
+			return
+		}
+		list.Content = lo.Reject(list.Content, func(it *yaml.Node, _ int) bool {
+			return strings.EqualFold(subscribeNodeName(it), sub.Name)
+		})
+
+		if current := mappingGet(root, "current"); current != nil && strings.EqualFold(current.Value, sub.Name) {
+			current.Value = ""
+			if len(list.Content) > 0 {
+				current.Value = subscribeNodeName(list.Content[0])
+			}
+		}
+		return
+	})
+}
+
+// 设置当前订阅
+func (s *Service) SubscribeUse(name string) (err error) {
+	sub := s.subscribeFind(name)
+	if sub == nil {
+		return fmt.Errorf("订阅 [%s] 不存在", name)
+	}
+
+	return s.configEdit(func(root *yaml.Node) (err error) {
+		if mappingGet(root, "current") == nil {
+			//放在最前面
+			root.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: "current"}, {Kind: yaml.ScalarNode, Value: sub.Name}}, root.Content...)
+			return
+		}
+		mappingSet(root, "current", sub.Name)
+		return
+	})
+}
+
+// 更新指定的订阅, 名称为空时更新全部
+func (s *Service) SubscribeUpdate(ctx context.Context, names ...string) (err error) {
+	list := s.config.Subscribe
+	if len(names) > 0 {
+		list = nil
+		for _, name := range names {
+			sub, _ := lo.Find(s.config.Subscribe, nameEq(name))
+			if sub == nil {
+				return fmt.Errorf("订阅 [%s] 不存在", name)
+			}
+			list = append(list, sub)
+		}
+	}
+
+	var failed []string
+	for _, sub := range list {
+		if !s.subscribeUpdate(ctx, sub) {
+			failed = append(failed, sub.Name)
+		}
+	}
+	if len(failed) > 0 {
+		err = errors.New("更新失败: " + strings.Join(failed, ", "))
+	}
+	return
+}
+
+// 订阅列表
+func (s *Service) SubscribeList() (list []SubscribeInfo) {
+	now := time.Now()
+	for _, sub := range s.config.Subscribe {
+		info := SubscribeInfo{Name: sub.Name, Url: sub.source(), Cron: sub.Cron, Current: sub.Name == s.config.Current}
+		if sub.Cron != "" {
+			if schedule, err := cron.ParseStandard(sub.Cron); err == nil {
+				info.Next = schedule.Next(now)
+			}
+		}
+
+		fn := s.pathResolve(SUBSCRIBE_DIR, sub.Name+".yaml")
+		if stat, _ := os.Stat(fn); stat != nil {
+			info.Updated = stat.ModTime()
+			info.Proxies = proxyCountFile(fn)
+		}
+		list = append(list, info)
+	}
+	return
+}
+
+// 链接的主机名, 不显示路径和参数中的 token
+func (info SubscribeInfo) Host() string {
+	if u, err := url.Parse(info.Url); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return info.Url
+}
+
+// 订阅文件中的节点数量, 只读取 proxies 不解析
+func proxyCountFile(fn string) int {
+	var raw struct {
+		Proxies []yaml.Node
+	}
+	if err := readYaml(fn, &raw); err != nil {
+		return 0
+	}
+	return len(raw.Proxies)
+}
+
+// 订阅节点的名称, 未设置时使用链接的文件名
+func subscribeNodeName(node *yaml.Node) string {
+	var sub Subscribe
+	node.Decode(&sub)
+	if sub.Name == "" && sub.Url != "" {
+		return subscribeDefaultName(sub.Url)
+	}
+	return sub.Name
+}
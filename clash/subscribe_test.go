@@ -0,0 +1,84 @@
+package clash
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+)
+
+// 初始化的配置和 subscribe: [] 添加订阅后都是块格式
+func TestSubscribeAddBlockStyle(t *testing.T) {
+	for _, config := range []string{"", "current: \"\"\nsubscribe: []\n"} {
+		home := t.TempDir()
+		if config == "" {
+			if _, err := Init(home, InitOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(filepath.Join(home, CONFIG_FN), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		s := New(home)
+		if err := s.Load(); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a", "b"} {
+			if err := s.SubscribeAdd(&Subscribe{Name: name, Url: "https://example.com/" + name}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		data, _ := os.ReadFile(filepath.Join(home, CONFIG_FN))
+		want := "subscribe:\n  - name: a\n    url: https://example.com/a\n  - name: b\n    url: https://example.com/b\n"
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%q: 不是块格式\n%s", config, data)
+		}
+	}
+}
+
+// 链接中有引用或模板时需要指定名称, 加载和检查都报错
+func TestSubscribeNeedsName(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://example.com/sub?token=abc", true},
+		{"https://example.com/${env:TOKEN}", false},
+		{"https://example.com/sub?token=${file:token.txt}", false},
+		{"https://example.com/{{.Hostname}}.yaml", false},
+	}
+	for _, tt := range tests {
+		home := t.TempDir()
+		config := "subscribe:\n  - url: " + tt.url + "\n"
+		if err := os.WriteFile(filepath.Join(home, CONFIG_FN), []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := New(home).Load(); (err == nil) != tt.ok {
+			t.Errorf("%s: Load() = %v, want ok=%v", tt.url, err, tt.ok)
+		}
+		r := Check(home)
+		if failed := lo.ContainsBy(r.Errors, func(it string) bool { return strings.Contains(it, "请指定名称") }); failed == tt.ok {
+			t.Errorf("%s: Check() errors = %q", tt.url, r.Errors)
+		}
+	}
+}
+
+// 同一秒内多次更新, 每次的备份都保留
+func TestSubscribeUpdateBackup(t *testing.T) {
+	s := testService(t)
+	sub := s.subscribeFind("a")
+	for i := 0; i < 2; i++ {
+		if !s.subscribeUpdate(context.Background(), sub) {
+			t.Fatalf("第 %d 次更新失败", i+1)
+		}
+	}
+	backups, _ := filepath.Glob(s.pathResolve(SUBSCRIBE_DIR, "a.yaml-*.backup"))
+	if len(backups) != 2 {
+		t.Errorf("backups: %v", backups)
+	}
+}
@@ -0,0 +1,198 @@
+package clash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/samber/lo"
+)
+
+const DEFAULT_TOKEN_TTL = time.Hour
+
+// 更新订阅前先请求令牌, 令牌在过期前缓存
+// 订阅的链接, 请求头和Body中可以用 {{.Token}} 引用令牌, 都没有引用时添加请求头 Authorization: Bearer <令牌>
+type SubscribeAuth struct {
+	Url     string        //令牌的请求链接
+	Method  string        //HTTP方法, 默认POST
+	Headers []string      //请求头
+	Body    string        //请求的Body, 如登录的用户名和密码
+	Token   string        //令牌在JSON响应中的路径, 如 data.token; 默认 access_token 或 token, 响应不是JSON时使用整个响应
+	Expires string        //有效期(秒)在JSON响应中的路径, 默认 expires_in
+	TTL     time.Duration //响应中没有有效期时的缓存时间, 默认1h
+}
+
+// 模板中可以使用的变量, 如 {{.Hostname}}, {{.Date}}, {{.Time.Format "0102"}}, {{env "NAME"}}
+type templateData struct {
+	Name     string //订阅名称
+	Hostname string
+	Date     string //2006-01-02
+	Time     time.Time
+	Token    string
+}
+
+type cachedToken struct {
+	value   string
+	expires time.Time
+}
+
+func (s *Service) templateData(sub *Subscribe) *templateData {
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	return &templateData{Name: sub.Name, Hostname: hostname, Date: now.Format(time.DateOnly), Time: now}
+}
+
+// 先执行模板, 再替换引用的环境变量和文件
+func (s *Service) render(text string, data *templateData) (out string, err error) {
+	out = text
+	if strings.Contains(text, "{{") {
+		var tpl *template.Template
+		if tpl, err = template.New("").Option("missingkey=error").Funcs(template.FuncMap{"env": os.Getenv}).Parse(text); err != nil {
+			return
+		}
+		var sb strings.Builder
+		if err = tpl.Execute(&sb, data); err != nil {
+			return
+		}
+		out = sb.String()
+	}
+	return s.expandSecret(out)
+}
+
+// 渲染订阅的链接, 请求头和Body
+func (s *Service) subscribeRender(sub *Subscribe, data *templateData) (link string, headers []string, body string, err error) {
+	link = sub.Url
+	if sub.UrlFile != "" {
+		link = "${file:" + sub.UrlFile + "}"
+	}
+	if link, err = s.render(link, data); err != nil {
+		return
+	}
+	for _, it := range sub.Headers {
+		var h string
+		if h, err = s.render(it, data); err != nil {
+			return
+		}
+		headers = append(headers, h)
+	}
+	body, err = s.render(sub.Body, data)
+	return
+}
+
+// 更新时实际使用的链接, 请求头和Body, 需要令牌时先请求令牌
+func (s *Service) subscribeRequest(ctx context.Context, sub *Subscribe) (link string, headers []string, body string, err error) {
+	data := s.templateData(sub)
+	if sub.Auth != nil {
+		if data.Token, err = s.subscribeToken(ctx, sub, data); err != nil {
+			err = fmt.Errorf("请求令牌失败: %w", err)
+			return
+		}
+	}
+
+	if link, headers, body, err = s.subscribeRender(sub, data); err != nil {
+		return
+	}
+
+	usesToken := func(it string) bool { return strings.Contains(it, ".Token") }
+	if sub.Auth != nil && !usesToken(sub.Url) && !usesToken(sub.Body) && !lo.ContainsBy(sub.Headers, usesToken) {
+		headers = append(headers, "Authorization=Bearer "+data.Token)
+	}
+	return
+}
+
+// 缓存中未过期的令牌, 否则重新请求
+func (s *Service) subscribeToken(ctx context.Context, sub *Subscribe, data *templateData) (token string, err error) {
+	if it, ok := s.tokens.Load(sub.Name); ok {
+		if cached := it.(*cachedToken); time.Now().Before(cached.expires) {
+			return cached.value, nil
+		}
+	}
+
+	auth := sub.Auth
+	link, err := s.render(auth.Url, data)
+	if err != nil {
+		return
+	}
+	body, err := s.render(auth.Body, data)
+	if err != nil {
+		return
+	}
+
+	method := lo.Ternary(auth.Method != "", auth.Method, http.MethodPost)
+	req, err := http.NewRequestWithContext(ctx, method, link, strings.NewReader(body))
+	if err != nil {
+		return
+	}
+	if strings.HasPrefix(strings.TrimSpace(body), "{") {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, it := range auth.Headers {
+		var h string
+		if h, err = s.render(it, data); err != nil {
+			return
+		}
+		k, v, _ := strings.Cut(h, "=")
+		req.Header.Set(k, v)
+	}
+
+	logs.Infof("[订阅] [%s] 请求令牌... %s", sub.Name, redactUrl(auth.Url))
+	client := &http.Client{Timeout: 10 * time.Second, Transport: newTransport()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", redactError(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf(resp.Status)
+	}
+
+	ttl := lo.Ternary(auth.TTL > 0, auth.TTL, DEFAULT_TOKEN_TTL)
+	var doc any
+	if json.Unmarshal(raw, &doc) != nil {
+		token = strings.TrimSpace(string(raw))
+	} else {
+		var ok bool
+		if auth.Token != "" {
+			token, ok = jsonPath(doc, auth.Token).(string)
+		} else if token, ok = jsonPath(doc, "access_token").(string); !ok {
+			token, ok = jsonPath(doc, "token").(string)
+		}
+		if !ok {
+			return "", fmt.Errorf("响应中没有令牌")
+		}
+		if expires, ok := jsonPath(doc, lo.Ternary(auth.Expires != "", auth.Expires, "expires_in")).(float64); ok && expires > 0 {
+			ttl = time.Duration(expires) * time.Second
+		}
+	}
+	if token == "" {
+		return "", fmt.Errorf("令牌为空")
+	}
+
+	//提前一点过期, 避免请求订阅时刚好过期
+	s.tokens.Store(sub.Name, &cachedToken{value: token, expires: time.Now().Add(ttl * 9 / 10)})
+	return
+}
+
+// 按 a.b.c 取出JSON中的值
+func jsonPath(doc any, path string) any {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := doc.(map[string]any)
+		if !ok {
+			return nil
+		}
+		doc = m[key]
+	}
+	return doc
+}
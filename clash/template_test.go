@@ -0,0 +1,44 @@
+package clash
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJsonPath(t *testing.T) {
+	var doc any
+	data := `{"token": "abc", "data": {"token": "def", "expires": 3600, "user": {"id": 1}, "list": [1, 2]}, "empty": null}`
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want any
+	}{
+		{"token", "abc"},
+		{"data.token", "def"},
+		{"data.expires", float64(3600)},
+		{"data.user.id", float64(1)},
+		{"data.user", map[string]any{"id": float64(1)}},
+		{"data.list", []any{float64(1), float64(2)}},
+		{"data.list.0", nil},
+		{"data.missing", nil},
+		{"missing.token", nil},
+		{"token.length", nil},
+		{"empty", nil},
+		{"empty.token", nil},
+		{"", nil},
+		{"data.", nil},
+	}
+	for _, tt := range tests {
+		if got := jsonPath(doc, tt.path); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("jsonPath(%q) = %#v, want %#v", tt.path, got, tt.want)
+		}
+	}
+
+	if got := jsonPath("text", "token"); got != nil {
+		t.Errorf("jsonPath(非对象) = %#v", got)
+	}
+}
@@ -0,0 +1,233 @@
+package clash
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/Dreamacro/clash/config"
+	"github.com/Dreamacro/clash/constant"
+	"github.com/Dreamacro/clash/hub/executor"
+	"github.com/hxnas/hlash/pkg/logs"
+	"github.com/samber/lo"
+)
+
+const (
+	CHAIN_NAME     = "CLASH"
+	CHAIN_NAME_DNS = "CLASH_DNS"
+)
+
+// 默认不代理的目标网段
+var bypassDefault = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+}
+
+// 添加透明代理规则
+func (s *Service) forward(ctx context.Context) (err error) {
+	if runtime.GOOS != "linux" {
+		logs.Warnf("[透明代理] 不支持该系统: %s", runtime.GOOS)
+		return
+	}
+
+	redirPort := s.clash.General.RedirPort
+	if redirPort == 0 {
+		logs.Warnf("[透明代理] redir-port 未设置, 跳过")
+		return
+	}
+
+	//清理上次残留的规则
+	s.backward()
+
+	bypass := s.config.Transparent.Bypass
+	if len(bypass) == 0 {
+		bypass = bypassDefault
+	} else if !lo.Contains(bypass, "127.0.0.0/8") {
+		//自定义的网段也不能转发回环地址, 否则本机访问 API 等也会进入内核
+		bypass = append([]string{"127.0.0.0/8"}, bypass...)
+	}
+
+	rules := []string{fmt.Sprintf("-t nat -N %s", CHAIN_NAME)}
+
+	//fake-ip 的地址段必须进入内核, 优先于不代理的网段
+	if fakeIP := s.fakeIPRange(); fakeIP != "" {
+		rules = append(rules, fmt.Sprintf("-t nat -A %s -d %s -p tcp -j REDIRECT --to-ports %d", CHAIN_NAME, fakeIP, redirPort))
+	}
+
+	for _, cidr := range bypass {
+		rules = append(rules, fmt.Sprintf("-t nat -A %s -d %s -j RETURN", CHAIN_NAME, cidr))
+	}
+	rules = append(rules,
+		fmt.Sprintf("-t nat -A %s -p tcp -j REDIRECT --to-ports %d", CHAIN_NAME, redirPort),
+		fmt.Sprintf("-t nat -A PREROUTING -p tcp -j %s", CHAIN_NAME),
+	)
+	if s.config.Transparent.Local {
+		rules = append(rules, "-t nat -A OUTPUT -p tcp"+s.markExclude()+" -j "+CHAIN_NAME)
+	}
+
+	//DNS劫持插到最前面, 目标是局域网内的DNS服务器也要劫持
+	if s.config.Transparent.DNS {
+		dnsRules, e := s.dnsHijackRules(redirPort)
+		if e != nil {
+			err = e
+			return
+		}
+		rules = append(rules, dnsRules...)
+	}
+
+	logs.Infof("[透明代理] 添加规则, 转发到端口: %d", redirPort)
+	if err = iptables(ctx, false, rules...); err != nil {
+		s.backward()
+	}
+	return
+}
+
+// 清除透明代理规则
+func (s *Service) backward() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+
+	iptables(context.Background(), true,
+		fmt.Sprintf("-t nat -D PREROUTING -p tcp -j %s", CHAIN_NAME),
+		"-t nat -D OUTPUT -p tcp"+s.markExclude()+" -j "+CHAIN_NAME,
+		fmt.Sprintf("-t nat -D OUTPUT -p tcp -j %s", CHAIN_NAME),
+		fmt.Sprintf("-t nat -F %s", CHAIN_NAME),
+		fmt.Sprintf("-t nat -X %s", CHAIN_NAME),
+		fmt.Sprintf("-t nat -D PREROUTING -p udp --dport 53 -j %s", CHAIN_NAME_DNS),
+		fmt.Sprintf("-t nat -D PREROUTING -p tcp --dport 53 -j %s", CHAIN_NAME_DNS),
+		"-t nat -D OUTPUT -p udp --dport 53"+s.markExclude()+" -j "+CHAIN_NAME_DNS,
+		"-t nat -D OUTPUT -p tcp --dport 53"+s.markExclude()+" -j "+CHAIN_NAME_DNS,
+		fmt.Sprintf("-t nat -D OUTPUT -p udp --dport 53 -j %s", CHAIN_NAME_DNS),
+		fmt.Sprintf("-t nat -D OUTPUT -p tcp --dport 53 -j %s", CHAIN_NAME_DNS),
+		fmt.Sprintf("-t nat -F %s", CHAIN_NAME_DNS),
+		fmt.Sprintf("-t nat -X %s", CHAIN_NAME_DNS),
+	)
+}
+
+// DNS劫持的规则, UDP转到内核的DNS服务, 内核的DNS服务不支持TCP, TCP交给redir端口经规则转发
+func (s *Service) dnsHijackRules(redirPort int) (rules []string, err error) {
+	if s.clash.DNS == nil || !s.clash.DNS.Enable || s.clash.DNS.Listen == "" {
+		err = fmt.Errorf("[透明代理] DNS劫持需要启用内核的DNS服务")
+		return
+	}
+
+	host, port, err := net.SplitHostPort(s.clash.DNS.Listen)
+	if err != nil {
+		err = fmt.Errorf("[透明代理] DNS监听地址无效: %s", s.clash.DNS.Listen)
+		return
+	}
+	dnsPort, _ := strconv.Atoi(port)
+	if dnsPort == 0 {
+		err = fmt.Errorf("[透明代理] DNS监听端口无效: %s", s.clash.DNS.Listen)
+		return
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		logs.Warnf("[透明代理] DNS监听在回环地址 %s, 局域网的DNS请求无法被劫持", s.clash.DNS.Listen)
+	}
+
+	rules = append(rules,
+		fmt.Sprintf("-t nat -N %s", CHAIN_NAME_DNS),
+		fmt.Sprintf("-t nat -A %s -p udp -j REDIRECT --to-ports %d", CHAIN_NAME_DNS, dnsPort),
+		fmt.Sprintf("-t nat -A %s -p tcp -j REDIRECT --to-ports %d", CHAIN_NAME_DNS, redirPort),
+		fmt.Sprintf("-t nat -I PREROUTING -p udp --dport 53 -j %s", CHAIN_NAME_DNS),
+		fmt.Sprintf("-t nat -I PREROUTING -p tcp --dport 53 -j %s", CHAIN_NAME_DNS),
+	)
+	if s.config.Transparent.Local {
+		rules = append(rules,
+			"-t nat -I OUTPUT -p udp --dport 53"+s.markExclude()+" -j "+CHAIN_NAME_DNS,
+			"-t nat -I OUTPUT -p tcp --dport 53"+s.markExclude()+" -j "+CHAIN_NAME_DNS,
+		)
+	}
+
+	logs.Infof("[透明代理] 劫持DNS, 转发到端口: %d", dnsPort)
+	return
+}
+
+// 没有配置上游DNS服务器时使用, 由内核解析以补全端口和 default-nameserver
+const dnsFallback = `dns:
+  enable: true
+  listen: 0.0.0.0:53
+  nameserver:
+    - 223.5.5.5:53
+    - tls://dns.google:853
+`
+
+// 劫持DNS需要内核的DNS服务, 未启用时启用 dns.yaml 或订阅中的DNS配置, 没有上游服务器时使用默认的
+// 默认配置解析失败时不启用, 添加规则时拒绝劫持
+func (s *Service) prepareDNS() {
+	dns := s.clash.DNS
+	if dns == nil || len(dns.NameServer) == 0 {
+		logs.Infof("[透明代理] 没有配置DNS服务器, 使用默认的DNS配置")
+		preset, err := executor.ParseWithBytes([]byte(dnsFallback))
+		if err != nil {
+			logs.Errorf("[透明代理] 默认的DNS配置无效: %v", err)
+			return
+		}
+		dns = preset.DNS
+	}
+
+	if !dns.Enable {
+		logs.Infof("[透明代理] DNS未启用, 劫持DNS时启用")
+		dns.Enable = true
+	}
+	if dns.Listen == "" {
+		dns.Listen = "0.0.0.0:53"
+	}
+	s.clash.DNS = dns
+}
+
+// 本机发出的流量中排除带标记的连接(内核和下载), 只用于 OUTPUT; 转发的流量没有标记
+func (s *Service) markExclude() string {
+	if mark := s.mark(); mark != 0 {
+		return fmt.Sprintf(" -m mark ! --mark %d", mark)
+	}
+	return ""
+}
+
+// fake-ip 模式下的地址段
+func (s *Service) fakeIPRange() string {
+	if dns := s.clash.DNS; dns != nil && dns.Enable && dns.EnhancedMode == constant.DNSFakeIP && dns.FakeIPRange != nil {
+		return dns.FakeIPRange.IPNet().String()
+	}
+	return ""
+}
+
+// 逐条执行iptables规则, ignoreErr 为真时忽略失败继续执行
+func iptables(ctx context.Context, ignoreErr bool, rules ...string) (err error) {
+	for _, rule := range rules {
+		if out, e := exec.CommandContext(ctx, "iptables", strings.Fields(rule)...).CombinedOutput(); e != nil && !ignoreErr {
+			err = fmt.Errorf("iptables %s: %v %s", rule, e, strings.TrimSpace(string(out)))
+			return
+		}
+	}
+	return
+}
+
+// 添加透明代理规则, 本机流量不经过, 出站流量使用默认标记
+//
+// Deprecated: 设置 transparent.enable 后由 Run 管理规则
+func Forward(ctx context.Context, redirPort int) (err error) {
+	s := &Service{clash: &config.Config{General: &config.General{}}, config: Config{Mark: DEFAULT_MARK}}
+	s.clash.General.RedirPort = redirPort
+	return s.forward(ctx)
+}
+
+// 清除透明代理规则
+//
+// Deprecated: 设置 transparent.enable 后由 Run 管理规则
+func Backward(ctx context.Context) (err error) {
+	s := &Service{config: Config{Mark: DEFAULT_MARK}}
+	s.backward()
+	return
+}
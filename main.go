@@ -1,15 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
-	"github.com/Dreamacro/clash/log"
 	"github.com/hxnas/hlash/clash"
 	"github.com/hxnas/hlash/pkg/cobra"
+	"github.com/hxnas/hlash/pkg/logs"
 	"github.com/hxnas/hlash/pkg/svc"
 )
 
@@ -22,7 +34,7 @@ const ENV_CLASH_HOME_DIR = "CLASH_HOME_DIR"
 
 func main() {
 	cobra.Init(Description, Version)
-	cobra.Run(commandRun(), commandSvc())
+	cobra.Run(commandRun(), commandInit(), commandCheck(), commandConfig(), commandSubscribe(), commandProvider(), commandRule(), commandCtl(), commandUI(), commandSvc())
 }
 
 func homeDirFromEnv() string {
@@ -33,34 +45,707 @@ func homeDirFromEnv() string {
 	return homeDir
 }
 
-func clashRun(ctx context.Context, homeDir string) {
-	log.Infoln("[启动] %s", time.Now().Format(time.RFC3339))
-	if err := clash.New(homeDir).Run(ctx); err != nil {
-		log.Fatalln("%v", err)
-	}
+func clashRun(ctx context.Context, homeDir, logLevel string) error {
+	logs.Infof("[启动] %s", time.Now().Format(time.RFC3339))
+	s := clash.New(homeDir)
+	s.SetLogLevel(logLevel)
+	return s.Run(ctx)
 }
 
 func commandRun() *cobra.Command {
 	c := &cobra.Command{Use: "run", Short: "运行"}
 	c.Flags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+	c.Flags().String("log-level", "", "日志级别: debug, info, warn, error, silent, 默认使用配置文件")
+	c.Run = func(cmd *cobra.Command, args []string) {
+		homeDir, _ := cmd.Flags().GetString("home")
+		logLevel, _ := cmd.Flags().GetString("log-level")
+		if err := clashRun(cmd.Context(), homeDir, logLevel); err != nil {
+			logs.Errorf("%v", err)
+			os.Exit(1)
+		}
+	}
+	return c
+}
+
+func commandInit() *cobra.Command {
+	c := &cobra.Command{Use: "init", Short: "初始化数据目录"}
+	c.Flags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+	c.Flags().BoolP("interactive", "i", false, "交互式输入")
+	c.Flags().String("url", "", "订阅链接")
+	c.Flags().Int("port", 7890, "混合端口")
+	c.Flags().Bool("lan", true, "允许局域网的连接")
 	c.Run = func(cmd *cobra.Command, args []string) {
 		homeDir, _ := cmd.Flags().GetString("home")
-		clashRun(cmd.Context(), homeDir)
+		interactive, _ := cmd.Flags().GetBool("interactive")
+
+		var opt clash.InitOptions
+		opt.Subscribe, _ = cmd.Flags().GetString("url")
+		opt.MixedPort, _ = cmd.Flags().GetInt("port")
+		opt.AllowLan, _ = cmd.Flags().GetBool("lan")
+
+		if interactive {
+			if err := initPrompt(&opt); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}
+
+		created, err := clash.Init(homeDir, opt)
+		for _, fn := range created {
+			fmt.Fprintf(os.Stderr, "写入 %s\n", fn)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		if len(created) == 0 {
+			fmt.Fprintln(os.Stderr, "文件都已存在, 未做修改")
+		}
+	}
+	return c
+}
+
+func commandCheck() *cobra.Command {
+	c := &cobra.Command{Use: "check", Short: "检查配置, 不启动内核"}
+	c.Flags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+	c.Run = func(cmd *cobra.Command, args []string) {
+		homeDir, _ := cmd.Flags().GetString("home")
+		r := clash.Check(homeDir)
+
+		for _, it := range r.Errors {
+			fmt.Printf("错误: %s\n", it)
+		}
+		for _, it := range r.Warnings {
+			fmt.Printf("警告: %s\n", it)
+		}
+
+		if sum := r.Summary; sum != nil {
+			if len(r.Errors)+len(r.Warnings) > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("当前订阅: %s\n", sum.Current)
+			fmt.Printf("端口: mixed=%d http=%d socks=%d redir=%d tproxy=%d\n", sum.MixedPort, sum.Port, sum.SocksPort, sum.RedirPort, sum.TProxyPort)
+			fmt.Printf("局域网: %t\n", sum.AllowLan)
+			fmt.Printf("模式: %s\n", sum.Mode)
+			fmt.Printf("内核日志: %s\n", sum.LogLevel)
+			api, dns := "未启用", "未启用"
+			if sum.Controller != "" {
+				api = sum.Controller
+			}
+			if sum.DNS != "" {
+				dns = sum.DNS + " " + sum.DNSMode
+			}
+			fmt.Printf("API: %s\n", api)
+			fmt.Printf("DNS: %s\n", dns)
+			fmt.Printf("节点: %d, 策略组: %d, 节点集: %d, 规则: %d\n", sum.Proxies, sum.Groups, sum.Providers, sum.Rules)
+			fmt.Printf("透明代理: %t, 流量标记: %d\n", sum.Transparent, sum.Mark)
+		}
+
+		if len(r.Errors) > 0 {
+			os.Exit(1)
+		}
 	}
 	return c
 }
 
+func commandConfig() *cobra.Command {
+	command := &cobra.Command{Use: "config", Short: "配置"}
+
+	show := &cobra.Command{Use: "show", Short: "输出合并后生效的配置, 隐藏口令和密码"}
+	show.Flags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+	show.Flags().Bool("diff", false, "输出与订阅文件的差异")
+	show.Run = func(cmd *cobra.Command, args []string) {
+		homeDir, _ := cmd.Flags().GetString("home")
+		diff, _ := cmd.Flags().GetBool("diff")
+
+		s := clash.New(homeDir)
+		err := s.Load()
+		var out []byte
+		if err == nil {
+			out, err = s.ConfigShow(diff)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+	}
+
+	command.AddCommand(show)
+	return command
+}
+
+func commandSubscribe() *cobra.Command {
+	command := &cobra.Command{Use: "subscribe", Aliases: []string{"sub"}, Short: "订阅"}
+	command.PersistentFlags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+
+	load := func(cmd *cobra.Command) *clash.Service {
+		homeDir, _ := cmd.Flags().GetString("home")
+		s := clash.New(homeDir)
+		if err := s.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return s
+	}
+
+	exit := func(err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	add := &cobra.Command{Use: "add <url>", Short: "添加订阅", Args: cobra.ExactArgs(1)}
+	add.Flags().String("name", "", "名称, 默认使用链接的文件名")
+	add.Flags().String("cron", "", "更新计划, 如 0 4 * * *")
+	add.Flags().StringArray("header", nil, "更新时使用的HTTP请求头, 如 User-Agent=clash, 可多次指定")
+	add.Flags().String("method", "", "更新时使用的HTTP方法")
+	add.Flags().String("body", "", "更新请求的Body参数")
+	add.Run = func(cmd *cobra.Command, args []string) {
+		sub := &clash.Subscribe{Url: args[0]}
+		sub.Name, _ = cmd.Flags().GetString("name")
+		sub.Cron, _ = cmd.Flags().GetString("cron")
+		sub.Headers, _ = cmd.Flags().GetStringArray("header")
+		sub.Method, _ = cmd.Flags().GetString("method")
+		sub.Body, _ = cmd.Flags().GetString("body")
+		exit(load(cmd).SubscribeAdd(sub))
+		fmt.Fprintf(os.Stderr, "已添加订阅 [%s]\n", sub.Name)
+	}
+
+	update := &cobra.Command{Use: "update [name...]", Short: "下载或更新订阅, 不需要运行中的服务"}
+	update.Flags().Bool("all", false, "更新全部订阅")
+	update.Run = func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		if len(args) == 0 && !all {
+			exit(fmt.Errorf("请指定订阅名称或 --all"))
+		}
+		exit(load(cmd).SubscribeUpdate(cmd.Context(), args...))
+	}
+
+	command.AddCommand(
+		add,
+		&cobra.Command{Use: "remove <name>", Aliases: []string{"rm"}, Short: "删除订阅, 不删除已下载的文件", Args: cobra.ExactArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			exit(load(cmd).SubscribeRemove(args[0]))
+		}},
+		&cobra.Command{Use: "list", Aliases: []string{"ls"}, Short: "订阅列表", Run: func(cmd *cobra.Command, args []string) {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "  名称\t链接\t更新计划\t更新时间\t下次更新\t节点")
+			for _, it := range load(cmd).SubscribeList() {
+				mark, updated, next := " ", "-", "-"
+				if it.Current {
+					mark = "*"
+				}
+				if !it.Updated.IsZero() {
+					updated = it.Updated.Format(time.DateTime)
+				}
+				if !it.Next.IsZero() {
+					next = it.Next.Format(time.DateTime)
+				}
+				fmt.Fprintf(w, "%s %s\t%s\t%s\t%s\t%s\t%d\n", mark, it.Name, it.Host(), it.Cron, updated, next, it.Proxies)
+			}
+			w.Flush()
+		}},
+		update,
+		&cobra.Command{Use: "use <name>", Short: "设置当前订阅, 重启服务后生效", Args: cobra.ExactArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			exit(load(cmd).SubscribeUse(args[0]))
+		}},
+		&cobra.Command{Use: "encrypt", Short: "加密 config.yaml 中的订阅列表, 密钥为环境变量 " + clash.SECRET_KEY_ENV, Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {
+			exit(load(cmd).SubscribeEncrypt(true))
+		}},
+		&cobra.Command{Use: "decrypt", Short: "解密 config.yaml 中的订阅列表", Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {
+			exit(load(cmd).SubscribeEncrypt(false))
+		}},
+	)
+	return command
+}
+
+func commandProvider() *cobra.Command {
+	command := &cobra.Command{Use: "provider", Aliases: []string{"pv"}, Short: "订阅中的 proxy-providers, 不需要运行中的服务"}
+	command.PersistentFlags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+	command.PersistentFlags().String("sub", "", "订阅名称, 默认为当前订阅")
+
+	load := func(cmd *cobra.Command) (s *clash.Service, sub string) {
+		homeDir, _ := cmd.Flags().GetString("home")
+		sub, _ = cmd.Flags().GetString("sub")
+		s = clash.New(homeDir)
+		if err := s.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	command.AddCommand(
+		&cobra.Command{Use: "list", Aliases: []string{"ls"}, Short: "provider 列表, 显示文件的大小和更新时间", Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {
+			s, sub := load(cmd)
+			list, err := s.ProviderList(sub)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			printProviders(list)
+		}},
+		&cobra.Command{Use: "update [name...]", Short: "下载 http 类型的 provider, 不指定名称时下载全部; 实例运行中时由实例下载", Run: func(cmd *cobra.Command, args []string) {
+			homeDir, _ := cmd.Flags().GetString("home")
+			sub, _ := cmd.Flags().GetString("sub")
+
+			//实例运行中时由实例下载, 避免与内核同时写入文件, 是当前订阅时立即重新加载
+			if client, err := clash.NewClient(homeDir); err == nil {
+				client.SetTimeout(2 * time.Second)
+				if _, err = client.Do(cmd.Context(), http.MethodGet, "/hlash/status", nil); err == nil {
+					query := url.Values{"name": args}
+					if sub != "" {
+						query.Set("subscribe", sub)
+					}
+					client.SetTimeout(0)
+					var list []*clash.ProviderInfo
+					data, err := client.Do(cmd.Context(), http.MethodPost, "/hlash/providers/update?"+query.Encode(), nil)
+					if err == nil {
+						err = json.Unmarshal(data, &list)
+					}
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "%v\n", err)
+						os.Exit(1)
+					}
+					printProviders(list)
+					return
+				}
+			}
+
+			s, sub := load(cmd)
+			if err := s.ProviderUpdate(cmd.Context(), sub, args...); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}},
+	)
+	return command
+}
+
+func commandRule() *cobra.Command {
+	command := &cobra.Command{Use: "rule", Short: "本地规则集, 放在订阅的规则前面; 内置 direct(直连) 和 proxy(代理)"}
+	command.PersistentFlags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+
+	load := func(cmd *cobra.Command) *clash.Service {
+		homeDir, _ := cmd.Flags().GetString("home")
+		s := clash.New(homeDir)
+		if err := s.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return s
+	}
+
+	// 修改后通知运行中的实例立即生效
+	apply := func(cmd *cobra.Command, err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		homeDir, _ := cmd.Flags().GetString("home")
+		client, err := clash.NewClient(homeDir)
+		if err == nil {
+			client.SetTimeout(5 * time.Second)
+			_, err = client.Do(cmd.Context(), http.MethodPost, "/hlash/rules/reload", nil)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "没有连接到运行中的实例, 下次启动时生效: %v\n", err)
+			return
+		}
+		fmt.Fprintln(os.Stderr, "已生效")
+	}
+
+	add := &cobra.Command{Use: "add <set> <entry...>", Short: "添加条目, 如 hlash rule add direct example.com", Args: cobra.MinimumNArgs(2)}
+	add.Flags().Bool("exact", false, "domain 规则集中只匹配域名本身, 默认同时匹配子域名(+.example.com)")
+	add.Run = func(cmd *cobra.Command, args []string) {
+		exact, _ := cmd.Flags().GetBool("exact")
+		apply(cmd, load(cmd).RuleAdd(args[0], exact, args[1:]...))
+	}
+
+	command.AddCommand(
+		&cobra.Command{Use: "list", Aliases: []string{"ls"}, Short: "规则集列表", Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "名称\t类型\t策略\t文件\t条目")
+			for _, it := range load(cmd).RuleList() {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\n", it.Name, it.Behavior, it.Policy, it.Path, it.Entries)
+			}
+			w.Flush()
+		}},
+		&cobra.Command{Use: "show <set>", Short: "规则集中的条目", Args: cobra.ExactArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			payload, err := load(cmd).RuleShow(args[0])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			for _, it := range payload {
+				fmt.Println(it)
+			}
+		}},
+		add,
+		&cobra.Command{Use: "remove <set> <entry...>", Aliases: []string{"rm"}, Short: "删除条目", Args: cobra.MinimumNArgs(2), Run: func(cmd *cobra.Command, args []string) {
+			apply(cmd, load(cmd).RuleRemove(args[0], args[1:]...))
+		}},
+		&cobra.Command{Use: "reload", Short: "手动修改规则集文件后, 通知运行中的实例重新加载", Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {
+			apply(cmd, nil)
+		}},
+	)
+	return command
+}
+
+// provider 列表
+func printProviders(list []*clash.ProviderInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "名称\t类型\t路径\t更新间隔\t大小\t更新时间\t节点")
+	for _, it := range list {
+		kind, updated, size, proxies := it.Kind+"/"+it.Type, "-", "-", "-"
+		if !it.Supported {
+			kind += " (内核不支持)"
+		}
+		if !it.Updated.IsZero() {
+			updated = it.Updated.Format(time.DateTime) + " (" + time.Since(it.Updated).Round(time.Second).String() + "前)"
+			size = byteSize(it.Size)
+		}
+		if it.Proxies > 0 {
+			proxies = strconv.Itoa(it.Proxies)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", it.Name, kind, it.Path, it.Interval, size, updated, proxies)
+	}
+	w.Flush()
+}
+
+func commandCtl() *cobra.Command {
+	command := &cobra.Command{Use: "ctl", Short: "控制运行中的实例"}
+	command.PersistentFlags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+
+	// 请求运行中的实例, 出错时退出
+	request := func(cmd *cobra.Command, method, path string, body any, timeout time.Duration) []byte {
+		homeDir, _ := cmd.Flags().GetString("home")
+		client, err := clash.NewClient(homeDir)
+		if err == nil {
+			client.SetTimeout(timeout)
+			var reader io.Reader
+			if body != nil {
+				data, _ := json.Marshal(body)
+				reader = bytes.NewReader(data)
+			}
+			var data []byte
+			if data, err = client.Do(cmd.Context(), method, path, reader); err == nil {
+				return data
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+		return nil
+	}
+
+	status := &cobra.Command{Use: "status", Short: "运行状态", Args: cobra.NoArgs}
+	status.Flags().Bool("json", false, "以JSON格式输出")
+	status.Run = func(cmd *cobra.Command, args []string) {
+		data := request(cmd, http.MethodGet, "/hlash/status", nil, 10*time.Second)
+		if asJson, _ := cmd.Flags().GetBool("json"); asJson {
+			os.Stdout.Write(data)
+			return
+		}
+
+		var info clash.StatusInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		w := os.Stdout
+		fmt.Fprintf(w, "进程: %d\n", info.Pid)
+		fmt.Fprintf(w, "运行时间: %s (%s 启动)\n", info.Uptime, info.Started.Local().Format(time.DateTime))
+		fmt.Fprintf(w, "当前订阅: %s\n", info.Current)
+		if info.Preferred != "" {
+			fmt.Fprintf(w, "首选订阅: %s\n", info.Preferred)
+		}
+		if f := info.Failover; f != nil {
+			fmt.Fprintf(w, "故障转移: %s %s => %s, %s\n", f.Time.Local().Format(time.DateTime), f.From, f.To, f.Reason)
+		}
+		fmt.Fprintf(w, "模式: %s\n", info.Mode)
+		fmt.Fprintf(w, "连接: %d\n", info.Connections)
+		fmt.Fprintf(w, "流量: 上传 %s, 下载 %s\n", byteSize(info.Upload), byteSize(info.Download))
+		names := make([]string, 0, len(info.Subscribe))
+		for name := range info.Subscribe {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			st := info.Subscribe[name]
+			result := "成功"
+			if !st.Success {
+				result = "失败: " + st.Error
+			}
+			fmt.Fprintf(w, "订阅 [%s]: %s 更新%s\n", name, st.Time.Local().Format(time.DateTime), result)
+		}
+	}
+
+	switchCmd := &cobra.Command{Use: "switch <name>", Short: "切换订阅", Args: cobra.ExactArgs(1),
+		Long: "切换订阅, 并作为健康检查的首选: 故障转移后恢复时切换回该订阅, 而不是之前的订阅\n" +
+			"不使用 --save 时只在本次运行中有效, 重新加载配置或重启后恢复为 config.yaml 中的 current"}
+	switchCmd.Flags().Bool("save", false, "同时写入 config.yaml, 重启后仍然使用")
+	switchCmd.Run = func(cmd *cobra.Command, args []string) {
+		path := "/hlash/switch/" + url.PathEscape(args[0])
+		if save, _ := cmd.Flags().GetBool("save"); save {
+			path += "?save"
+		}
+		request(cmd, http.MethodPost, path, nil, 0)
+		fmt.Fprintf(os.Stderr, "已切换到 [%s]\n", args[0])
+	}
+
+	provider := &cobra.Command{Use: "provider", Aliases: []string{"pv"}, Short: "当前订阅的 proxy-providers"}
+	provider.AddCommand(
+		&cobra.Command{Use: "list", Aliases: []string{"ls"}, Short: "provider 列表, 包括内核加载的节点数量", Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {
+			var list []*clash.ProviderInfo
+			if err := json.Unmarshal(request(cmd, http.MethodGet, "/hlash/providers", nil, 10*time.Second), &list); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			printProviders(list)
+		}},
+		&cobra.Command{Use: "update [name]", Short: "强制下载 provider 并重新加载, 不指定名称时更新全部", Args: cobra.MaximumNArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			path := "/hlash/providers/update"
+			if len(args) > 0 {
+				path += "/" + url.PathEscape(args[0])
+			}
+			var list []*clash.ProviderInfo
+			if err := json.Unmarshal(request(cmd, http.MethodPost, path, nil, 0), &list); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			printProviders(list)
+		}},
+	)
+
+	command.AddCommand(
+		status,
+		provider,
+		&cobra.Command{Use: "reload", Short: "重新加载配置和当前订阅", Args: cobra.NoArgs, Run: func(cmd *cobra.Command, args []string) {
+			request(cmd, http.MethodPost, "/hlash/reload", nil, 0)
+			fmt.Fprintln(os.Stderr, "已重新加载")
+		}},
+		&cobra.Command{Use: "update <name>", Short: "更新订阅, 更新的是当前订阅时重新加载", Args: cobra.ExactArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			request(cmd, http.MethodPost, "/hlash/update/"+url.PathEscape(args[0]), nil, 0)
+			fmt.Fprintf(os.Stderr, "[%s] 更新完成\n", args[0])
+		}},
+		switchCmd,
+		&cobra.Command{Use: "mode <rule|global|direct>", Short: "切换代理模式", Args: cobra.ExactArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			mode := strings.ToLower(args[0])
+			if mode != "rule" && mode != "global" && mode != "direct" {
+				fmt.Fprintf(os.Stderr, "模式错误: %s\n", args[0])
+				os.Exit(1)
+			}
+			request(cmd, http.MethodPatch, "/configs", map[string]string{"mode": mode}, 10*time.Second)
+		}},
+	)
+	return command
+}
+
+// 可读的流量大小
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// 交互式输入初始化的选项
+func initPrompt(opt *clash.InitOptions) (err error) {
+	in := bufio.NewReader(os.Stdin)
+	if opt.Subscribe, err = prompt(in, "订阅链接", opt.Subscribe, nil); err != nil {
+		return
+	}
+
+	port, err := prompt(in, "混合端口", strconv.Itoa(opt.MixedPort), func(it string) (err error) {
+		if n, e := strconv.Atoi(it); e != nil || n < 0 || n > 65535 {
+			err = fmt.Errorf("端口应为 0-65535")
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	opt.MixedPort, _ = strconv.Atoi(port)
+
+	lan := "n"
+	if opt.AllowLan {
+		lan = "y"
+	}
+	lan, err = prompt(in, "允许局域网的连接(y/n)", lan, func(it string) (err error) {
+		if _, ok := parseYesNo(it); !ok {
+			err = fmt.Errorf("请输入 y 或 n")
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	opt.AllowLan, _ = parseYesNo(lan)
+	return
+}
+
+// 读取一行输入, 为空时使用默认值; valid 不通过时重新输入, 输入已结束时返回错误
+func prompt(in *bufio.Reader, label, def string, valid func(string) error) (line string, err error) {
+	for {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", label, def)
+		line, err = in.ReadString('\n')
+		if line = strings.TrimSpace(line); line == "" {
+			line = def
+		}
+		if valid == nil {
+			return line, nil
+		}
+		e := valid(line)
+		if e == nil {
+			return line, nil
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", e)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", label, e)
+		}
+	}
+}
+
+func parseYesNo(s string) (yes bool, ok bool) {
+	switch strings.ToLower(s) {
+	case "y", "yes", "true", "1":
+		return true, true
+	case "n", "no", "false", "0":
+		return false, true
+	}
+	return
+}
+
+// 安装服务的选项
+func svcInstallOptions(cmd *cobra.Command, s *svc.Service) (err error) {
+	s.UserName, _ = cmd.Flags().GetString("user")
+	s.Capabilities, _ = cmd.Flags().GetStringSlice("cap")
+	s.Option = map[string]any{}
+
+	envs, _ := cmd.Flags().GetStringArray("env")
+	for _, env := range envs {
+		k, v, ok := strings.Cut(env, "=")
+		if !ok || k == "" {
+			return fmt.Errorf("环境变量格式错误: %s", env)
+		}
+		if s.EnvVars == nil {
+			s.EnvVars = map[string]string{}
+		}
+		s.EnvVars[k] = v
+	}
+
+	restart, _ := cmd.Flags().GetString("restart")
+	switch restart {
+	case "always", "on-failure", "no":
+		s.Option["Restart"] = restart
+		s.Option["KeepAlive"] = restart == "always" //launchd
+	default:
+		return fmt.Errorf("重启策略错误: %s", restart)
+	}
+
+	s.NetworkOnline, _ = cmd.Flags().GetBool("network-online")
+
+	if logDir, _ := cmd.Flags().GetString("log-dir"); logDir != "" {
+		if logDir, err = filepath.Abs(logDir); err != nil {
+			return
+		}
+		s.Option["LogOutput"] = true
+		s.Option["LogDirectory"] = logDir
+	}
+	return
+}
+
+func commandUI() *cobra.Command {
+	command := &cobra.Command{Use: "ui", Aliases: []string{"dashboard"}, Short: "面板"}
+	command.PersistentFlags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+
+	load := func(cmd *cobra.Command) *clash.Service {
+		homeDir, _ := cmd.Flags().GetString("home")
+		s := clash.New(homeDir)
+		if err := s.Load(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return s
+	}
+
+	exit := func(err error) {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	command.AddCommand(
+		&cobra.Command{Use: "list", Short: "已安装的面板", Run: func(cmd *cobra.Command, args []string) {
+			list, err := load(cmd).DashboardList()
+			exit(err)
+			for _, it := range list {
+				mark := " "
+				if it.Current {
+					mark = "*"
+				}
+				fmt.Printf("%s %s\t%s\n", mark, it.Name, it.Updated.Format(time.DateTime))
+			}
+		}},
+		&cobra.Command{Use: "update <name>", Short: "下载或更新面板", Args: cobra.ExactArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			exit(load(cmd).DashboardUpdate(cmd.Context(), args[0]))
+		}},
+		&cobra.Command{Use: "use <name>", Short: "切换面板", Args: cobra.ExactArgs(1), Run: func(cmd *cobra.Command, args []string) {
+			exit(load(cmd).DashboardUse(args[0]))
+		}},
+	)
+	return command
+}
+
 func commandSvc() *cobra.Command {
 	command := &cobra.Command{Use: "svc", Aliases: []string{"service"}, Short: "服务"}
 
+	command.PersistentFlags().StringP("name", "n", "", "实例名称, 用于安装多个服务")
+
 	run := func(cmd *cobra.Command, args []string) {
 		name := cmd.Name()
 		homeDir, _ := cmd.Flags().GetString("home")
 		homeDir, _ = filepath.Abs(homeDir)
+		stopTimeout, _ := cmd.Flags().GetDuration("stop-timeout")
+		instance, _ := cmd.Flags().GetString("name")
 
-		s := &svc.Service{Name: "hlash"}
-		s.Run = func() { clashRun(cmd.Context(), homeDir) }
-		s.Arguments = []string{"svc", "run", "-d", homeDir}
+		s, err := svcNew(instance)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		s.StopTimeout = stopTimeout
+		logLevel, _ := cmd.Flags().GetString("log-level")
+		s.Run = func(ctx context.Context) error { return clashRun(ctx, homeDir, logLevel) }
+		s.Arguments = []string{"svc", "run", "-d", homeDir, "--stop-timeout", stopTimeout.String()}
+		if logLevel != "" {
+			s.Arguments = append(s.Arguments, "--log-level", logLevel)
+		}
+		if instance != "" {
+			s.Arguments = append(s.Arguments, "--name", instance)
+		}
+
+		if name == "status" {
+			if home := svcHomeDir(s.Name); home != "" && !cmd.Flags().Changed("home") {
+				homeDir = home
+			}
+			os.Exit(svcStatus(cmd, s, homeDir))
+		}
+
+		if name == "install" {
+			if err := svcInstallOptions(cmd, s); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+		}
 
 		msg, err := s.Control(name)
 		if msg != "" {
@@ -75,11 +760,198 @@ func commandSvc() *cobra.Command {
 
 	for i, name := range svc.ControlActions {
 		c := &cobra.Command{Use: name, Short: svc.ControlLabels[i], Run: run}
+		if name == "status" {
+			c.Flags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录, 默认使用服务安装时的目录")
+			c.Flags().Bool("json", false, "以JSON格式输出")
+		}
 		if name == "install" || name == "run" {
 			c.Flags().StringP("home", "d", homeDirFromEnv(), "数据和配置目录")
+			c.Flags().Duration("stop-timeout", svc.DEFAULT_STOP_TIMEOUT, "停止时等待退出的时间")
+			c.Flags().String("log-level", "", "日志级别: debug, info, warn, error, silent, 默认使用配置文件")
+		}
+		if name == "install" {
+			c.Flags().String("user", "", "运行服务的用户")
+			c.Flags().StringArray("env", nil, "环境变量, 如 HTTPS_PROXY=http://127.0.0.1:8080, 可多次指定")
+			c.Flags().String("restart", "always", "重启策略: always, on-failure, no")
+			c.Flags().Bool("network-online", true, "等待网络就绪后启动(systemd: After=network-online.target)")
+			c.Flags().String("log-dir", "", "标准输出和错误输出的日志目录, 为空时由系统管理")
+			c.Flags().StringSlice("cap", nil, "授予的 capabilities, 如 CAP_NET_ADMIN,CAP_NET_BIND_SERVICE (systemd)")
 		}
 		command.AddCommand(c)
 	}
 
+	command.AddCommand(&cobra.Command{Use: "list", Short: "已安装的实例", Run: func(cmd *cobra.Command, args []string) {
+		list, err := svc.List(SVC_NAME)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "服务\t实例\t状态\t数据目录")
+		for _, it := range list {
+			if it.Name != SVC_NAME && !strings.HasPrefix(it.Name, SVC_NAME+"-") {
+				continue
+			}
+			instance := strings.TrimPrefix(it.Name, SVC_NAME+"-")
+			if it.Name == SVC_NAME {
+				instance = ""
+			}
+			status, _ := (&svc.Service{Name: it.Name}).Status()
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", it.Name, instance, svc.StatusLabel(status), argValue(it.Arguments, "-d", "--home"))
+		}
+		w.Flush()
+	}})
+
+	//--instance 作为 --name 的别名
+	command.SetGlobalNormalizationFunc(func(f *cobra.FlagSet, name string) cobra.NormalizedName {
+		if name == "instance" {
+			name = "name"
+		}
+		return cobra.NormalizedName(name)
+	})
+
 	return command
 }
+
+const SVC_NAME = "hlash"
+
+var instanceNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// 根据实例名称生成服务
+func svcNew(instance string) (s *svc.Service, err error) {
+	if instance == "" {
+		s = &svc.Service{Name: SVC_NAME, DisplayName: "Hlash", Description: Description}
+		return
+	}
+
+	if !instanceNameRe.MatchString(instance) {
+		err = fmt.Errorf("实例名称只能包含字母, 数字, - 和 _: %s", instance)
+		return
+	}
+
+	s = &svc.Service{
+		Name:        SVC_NAME + "-" + instance,
+		DisplayName: fmt.Sprintf("Hlash (%s)", instance),
+		Description: fmt.Sprintf("%s (%s)", Description, instance),
+	}
+	return
+}
+
+// 已安装服务的数据目录
+func svcHomeDir(name string) string {
+	list, _ := svc.List(name)
+	for _, it := range list {
+		if it.Name == name {
+			return argValue(it.Arguments, "-d", "--home")
+		}
+	}
+	return ""
+}
+
+// 服务状态
+type svcStatusInfo struct {
+	Service   string                           `json:"service"`
+	State     string                           `json:"state"` //running, stopped, not-installed, unknown
+	Pid       int                              `json:"pid,omitempty"`
+	Started   *time.Time                       `json:"started,omitempty"`
+	Uptime    string                           `json:"uptime,omitempty"`
+	Home      string                           `json:"home"`
+	Current   string                           `json:"current,omitempty"`
+	Subscribe map[string]*clash.SubscribeState `json:"subscribe,omitempty"`
+	API       bool                             `json:"api"`
+	APIError  string                           `json:"api_error,omitempty"`
+}
+
+// 输出服务状态, 返回退出码: 运行中 0, 已停止 3, 未安装 4, 未知 1
+func svcStatus(cmd *cobra.Command, s *svc.Service, homeDir string) (code int) {
+	info := svcStatusInfo{Service: s.Name, Home: homeDir}
+
+	status, err := s.Status()
+	switch {
+	case errors.Is(err, svc.ErrNotInstalled):
+		info.State, code = "not-installed", 4
+	case status == svc.StatusRunning:
+		info.State, code = "running", 0
+	case status == svc.StatusStopped:
+		info.State, code = "stopped", 3
+	default:
+		info.State, code = "unknown", 1
+	}
+
+	if c := clash.New(homeDir); c.Load() == nil {
+		info.Current = c.Current()
+	}
+
+	if state, _ := clash.ReadState(homeDir); state != nil {
+		info.Subscribe = state.Subscribe
+		if state.Alive() {
+			info.Pid = state.Pid
+			info.Started = &state.Started
+			info.Uptime = time.Since(state.Started).Round(time.Second).String()
+			if state.Current != "" {
+				info.Current = state.Current
+			}
+		}
+	}
+
+	if info.Pid > 0 {
+		ctx, cancel := context.WithTimeout(cmd.Context(), 3*time.Second)
+		client, err := clash.NewClient(homeDir)
+		if err == nil {
+			_, err = client.Do(ctx, http.MethodGet, "/version", nil)
+		}
+		cancel()
+		if info.API = err == nil; err != nil {
+			info.APIError = err.Error()
+		}
+	}
+
+	if asJson, _ := cmd.Flags().GetBool("json"); asJson {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(info)
+		return
+	}
+
+	labels := map[string]string{"running": "运行中", "stopped": "已停止", "not-installed": "未安装", "unknown": "未知"}
+	w := os.Stdout
+	fmt.Fprintf(w, "服务: %s\n", info.Service)
+	fmt.Fprintf(w, "状态: %s\n", labels[info.State])
+	if info.Pid > 0 {
+		fmt.Fprintf(w, "进程: %d\n", info.Pid)
+		fmt.Fprintf(w, "运行时间: %s (%s 启动)\n", info.Uptime, info.Started.Format(time.DateTime))
+	}
+	fmt.Fprintf(w, "数据目录: %s\n", info.Home)
+	fmt.Fprintf(w, "当前订阅: %s\n", info.Current)
+	if st := info.Subscribe[info.Current]; st != nil {
+		result := "成功"
+		if !st.Success {
+			result = "失败: " + st.Error
+		}
+		fmt.Fprintf(w, "最后更新: %s %s\n", st.Time.Format(time.DateTime), result)
+	}
+	if info.Pid > 0 {
+		api := "正常"
+		if !info.API {
+			api = "无响应: " + info.APIError
+		}
+		fmt.Fprintf(w, "RESTful API: %s\n", api)
+	}
+	return
+}
+
+// 从参数列表中取出指定参数的值
+func argValue(args []string, names ...string) string {
+	for i, arg := range args {
+		for _, name := range names {
+			if arg == name && i+1 < len(args) {
+				return args[i+1]
+			}
+			if v, ok := strings.CutPrefix(arg, name+"="); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
@@ -5,11 +5,15 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type (
+	FlagSet            = pflag.FlagSet
+	NormalizedName     = pflag.NormalizedName
 	Command            = cobra.Command
 	CompletionOptions  = cobra.CompletionOptions
 	FParseErrWhitelist = cobra.FParseErrWhitelist
@@ -18,6 +22,13 @@ type (
 	ShellCompDirective = cobra.ShellCompDirective
 )
 
+var (
+	ExactArgs    = cobra.ExactArgs
+	MaximumNArgs = cobra.MaximumNArgs
+	MinimumNArgs = cobra.MinimumNArgs
+	NoArgs       = cobra.NoArgs
+)
+
 var Description, Version string
 
 func Init(description, version string) {
@@ -31,7 +42,7 @@ func Run(subs ...*Command) {
 	root.AddCommand(subs...)
 	fixCommand(root, true)
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 	root.ExecuteContext(ctx)
 }
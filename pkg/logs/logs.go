@@ -0,0 +1,146 @@
+package logs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+	SILENT
+)
+
+var levelNames = [...]string{"debug", "info", "warn", "error", "silent"}
+
+func (l Level) String() string {
+	if l >= 0 && int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return "unknown"
+}
+
+// 解析日志级别, 支持 debug, info, warn(warning), error, silent
+func ParseLevel(s string) (level Level, err error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		level = DEBUG
+	case "", "info":
+		level = INFO
+	case "warn", "warning":
+		level = WARN
+	case "error":
+		level = ERROR
+	case "silent":
+		level = SILENT
+	default:
+		err = fmt.Errorf("未知的日志级别: %s", s)
+	}
+	return
+}
+
+// 日志选项
+type Options struct {
+	Level      string        //日志级别
+	Json       bool          //以JSON格式输出
+	File       string        //日志文件, 为空时只输出到标准输出
+	MaxSize    int           //单个日志文件的大小(MB), 超过后轮转, 默认10
+	MaxFileAge time.Duration //单个日志文件的写入时间, 超过后轮转, 默认1天
+	MaxAge     time.Duration //轮转后的日志保留时间, 默认7天
+	MaxBackups int           //轮转后的日志保留个数, 默认5
+}
+
+var (
+	mu     sync.Mutex
+	level  = INFO
+	asJson bool
+	stdout io.Writer = os.Stdout
+	file   *rotateWriter
+)
+
+// 设置日志, 可以多次调用, 之前打开的日志文件会被关闭
+func Setup(opt Options) (err error) {
+	lv, err := ParseLevel(opt.Level)
+	if err != nil {
+		return
+	}
+
+	var w *rotateWriter
+	if opt.File != "" {
+		if w, err = newRotateWriter(opt); err != nil {
+			return
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+	}
+	level, asJson, file = lv, opt.Json, w
+	return
+}
+
+// 关闭日志文件
+func Close() {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Close()
+		file = nil
+	}
+}
+
+func Debugf(format string, args ...any) { output(DEBUG, "", format, args...) }
+func Infof(format string, args ...any)  { output(INFO, "", format, args...) }
+func Warnf(format string, args ...any)  { output(WARN, "", format, args...) }
+func Errorf(format string, args ...any) { output(ERROR, "", format, args...) }
+
+// 内核的日志, 内核自己会输出到标准输出, 这里只写入日志文件
+func Core(lv Level, msg string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if file != nil {
+		file.Write(format(time.Now(), lv, "core", msg))
+	}
+}
+
+func output(lv Level, source, f string, args ...any) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if lv < level {
+		return
+	}
+
+	line := format(time.Now(), lv, source, fmt.Sprintf(f, args...))
+	stdout.Write(line)
+	if file != nil {
+		file.Write(line)
+	}
+}
+
+func format(t time.Time, lv Level, source, msg string) []byte {
+	if asJson {
+		entry := map[string]string{"time": t.Format(time.RFC3339Nano), "level": lv.String(), "msg": msg}
+		if source != "" {
+			entry["source"] = source
+		}
+		data, _ := json.Marshal(entry)
+		return append(data, '\n')
+	}
+
+	if source != "" {
+		msg = "[" + source + "] " + msg
+	}
+	return []byte(fmt.Sprintf("%s %-5s %s\n", t.Format(time.RFC3339), strings.ToUpper(lv.String()), msg))
+}
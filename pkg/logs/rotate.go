@@ -0,0 +1,124 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 按大小和写入时间轮转的日志文件, 打开和轮转后按保留时间和个数清理
+type rotateWriter struct {
+	fn         string
+	maxSize    int64
+	maxFileAge time.Duration
+	maxAge     time.Duration
+	maxBackups int
+
+	f       *os.File
+	size    int64
+	started time.Time //当前文件开始写入的时间
+}
+
+func newRotateWriter(opt Options) (w *rotateWriter, err error) {
+	w = &rotateWriter{
+		fn:         opt.File,
+		maxSize:    int64(opt.MaxSize) << 20,
+		maxFileAge: opt.MaxFileAge,
+		maxAge:     opt.MaxAge,
+		maxBackups: opt.MaxBackups,
+	}
+	if w.maxSize <= 0 {
+		w.maxSize = 10 << 20
+	}
+	if w.maxFileAge <= 0 {
+		w.maxFileAge = 24 * time.Hour
+	}
+	if w.maxAge <= 0 {
+		w.maxAge = 7 * 24 * time.Hour
+	}
+	if w.maxBackups <= 0 {
+		w.maxBackups = 5
+	}
+
+	if err = w.open(); err != nil {
+		w = nil
+		return
+	}
+	//上次运行留下的文件超过写入时间时先轮转
+	if stat, e := os.Stat(w.fn); e == nil && w.size > 0 && time.Since(stat.ModTime()) > w.maxFileAge {
+		w.rotate()
+	} else {
+		w.cleanup()
+	}
+	return
+}
+
+func (w *rotateWriter) open() (err error) {
+	if err = os.MkdirAll(filepath.Dir(w.fn), 0755); err != nil {
+		return
+	}
+	if w.f, err = os.OpenFile(w.fn, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	if stat, e := w.f.Stat(); e == nil {
+		w.size = stat.Size()
+	}
+	w.started = time.Now()
+	return
+}
+
+func (w *rotateWriter) Write(p []byte) (n int, err error) {
+	if w.f == nil {
+		return len(p), nil
+	}
+	if w.size > 0 && (w.size+int64(len(p)) > w.maxSize || time.Since(w.started) > w.maxFileAge) {
+		w.rotate()
+		if w.f == nil {
+			return len(p), nil
+		}
+	}
+	n, err = w.f.Write(p)
+	w.size += int64(n)
+	return
+}
+
+func (w *rotateWriter) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// 当前文件改名为 <name>-<时间>.<ext>, 重新打开; 时间精确到纳秒, 同一秒内多次切换也不会覆盖
+func (w *rotateWriter) rotate() {
+	w.f.Close()
+	w.f = nil
+
+	ext := filepath.Ext(w.fn)
+	backup := strings.TrimSuffix(w.fn, ext) + "-" + time.Now().Format("20060102-150405.000000000") + ext
+	os.Rename(w.fn, backup)
+
+	w.open()
+	w.cleanup()
+}
+
+// 删除超过保留个数或保留时间的备份, 不包括当前文件
+func (w *rotateWriter) cleanup() {
+	ext := filepath.Ext(w.fn)
+	backups, _ := filepath.Glob(strings.TrimSuffix(w.fn, ext) + "-*" + ext)
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+
+	for i, fn := range backups {
+		stat, err := os.Stat(fn)
+		if err != nil {
+			continue
+		}
+		if i >= w.maxBackups || time.Since(stat.ModTime()) > w.maxAge {
+			os.Remove(fn)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package logs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func backups(t *testing.T, fn string) []string {
+	list, err := filepath.Glob(strings.TrimSuffix(fn, ".log") + "-*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestRotateSize(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "hlash.log")
+	w, err := newRotateWriter(Options{File: fn, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.maxSize = 10
+
+	for i := 0; i < 5; i++ {
+		w.Write([]byte("0123456789"))
+	}
+	if got := backups(t, fn); len(got) != 2 {
+		t.Errorf("按个数保留 2 个备份: %v", got)
+	}
+	if data, _ := os.ReadFile(fn); string(data) != "0123456789" {
+		t.Errorf("当前文件: %q", data)
+	}
+}
+
+func TestRotateAge(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "hlash.log")
+	w, err := newRotateWriter(Options{File: fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write([]byte("old\n"))
+	w.started = time.Now().Add(-w.maxFileAge - time.Minute)
+	w.Write([]byte("new\n"))
+	if got := backups(t, fn); len(got) != 1 {
+		t.Fatalf("超过写入时间应该轮转: %v", got)
+	}
+	if data, _ := os.ReadFile(fn); string(data) != "new\n" {
+		t.Errorf("当前文件: %q", data)
+	}
+}
+
+// 打开时清理过期的备份, 上次运行留下的过期文件先轮转
+func TestRotateOpen(t *testing.T) {
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "hlash.log")
+	for name, age := range map[string]time.Duration{"hlash-20200101-000000.000000000.log": 30 * 24 * time.Hour, "hlash.log": 48 * time.Hour} {
+		mtime := time.Now().Add(-age)
+		os.WriteFile(filepath.Join(dir, name), []byte("old\n"), 0644)
+		os.Chtimes(filepath.Join(dir, name), mtime, mtime)
+	}
+	keep := filepath.Join(dir, "hlash-20990101-000000.000000000.log")
+	os.WriteFile(keep, []byte("keep\n"), 0644)
+
+	w, err := newRotateWriter(Options{File: fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if got := backups(t, fn); len(got) != 2 || got[0] == keep || got[1] != keep {
+		t.Errorf("备份: %v", got)
+	}
+	if w.size != 0 {
+		t.Errorf("当前文件应该是新的: %d", w.size)
+	}
+}
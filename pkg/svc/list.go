@@ -0,0 +1,50 @@
+package svc
+
+import (
+	"strings"
+)
+
+// 已安装的服务
+type Installed struct {
+	Name      string
+	Arguments []string //启动参数, 不含程序路径
+}
+
+// 列出名称以 prefix 开头的已安装服务
+func List(prefix string) (list []Installed, err error) {
+	return listInstalled(prefix)
+}
+
+// 按空格拆分命令行, 支持双引号和 \" 转义
+func splitCommandLine(line string) (args []string) {
+	var (
+		cur     strings.Builder
+		quoted  bool
+		hasWord bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line) && line[i+1] == '"':
+			cur.WriteByte('"')
+			hasWord = true
+			i++
+		case c == '"':
+			quoted = !quoted
+			hasWord = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if hasWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				hasWord = false
+			}
+		default:
+			cur.WriteByte(c)
+			hasWord = true
+		}
+	}
+	if hasWord {
+		args = append(args, cur.String())
+	}
+	return
+}
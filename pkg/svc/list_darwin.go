@@ -0,0 +1,40 @@
+//go:build darwin
+
+package svc
+
+import (
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var plistArguments = regexp.MustCompile(`(?s)<key>ProgramArguments</key>\s*<array>(.*?)</array>`)
+var plistString = regexp.MustCompile(`<string>(.*?)</string>`)
+
+// launchd 的 ProgramArguments
+func listInstalled(prefix string) (list []Installed, err error) {
+	files, _ := filepath.Glob("/Library/LaunchDaemons/" + prefix + "*.plist")
+	for _, fn := range files {
+		data, e := os.ReadFile(fn)
+		if e != nil {
+			continue
+		}
+
+		m := plistArguments.FindSubmatch(data)
+		if m == nil {
+			continue
+		}
+
+		var args []string
+		for _, it := range plistString.FindAllSubmatch(m[1], -1) {
+			args = append(args, html.UnescapeString(string(it[1])))
+		}
+		if len(args) > 0 {
+			args = args[1:]
+		}
+		list = append(list, Installed{Name: strings.TrimSuffix(filepath.Base(fn), ".plist"), Arguments: args})
+	}
+	return
+}
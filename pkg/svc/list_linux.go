@@ -0,0 +1,64 @@
+//go:build linux
+
+package svc
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// systemd 的 ExecStart 或 sysv 脚本中的 cmd
+func listInstalled(prefix string) (list []Installed, err error) {
+	patterns := []struct {
+		glob   string
+		suffix string
+		key    string
+	}{
+		{"/etc/systemd/system/" + prefix + "*.service", ".service", "ExecStart="},
+		{"/etc/init.d/" + prefix + "*", "", "cmd="},
+	}
+
+	seen := map[string]bool{}
+	for _, p := range patterns {
+		files, _ := filepath.Glob(p.glob)
+		for _, fn := range files {
+			name := strings.TrimSuffix(filepath.Base(fn), p.suffix)
+			if seen[name] {
+				continue
+			}
+
+			if args, ok := readCommandLine(fn, p.key); ok {
+				seen[name] = true
+				list = append(list, Installed{Name: name, Arguments: args})
+			}
+		}
+	}
+	return
+}
+
+func readCommandLine(fn, key string) (args []string, ok bool) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, key) {
+			continue
+		}
+		line = strings.TrimPrefix(line, key)
+		if key == "cmd=" {
+			line = strings.Trim(line, `"`)
+		}
+		if args = splitCommandLine(line); len(args) > 0 {
+			args, ok = args[1:], true
+		}
+		return
+	}
+	return
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package svc
+
+import "errors"
+
+func listInstalled(string) (list []Installed, err error) {
+	err = errors.New("不支持该系统")
+	return
+}
@@ -0,0 +1,31 @@
+package svc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"/usr/bin/hlash", []string{"/usr/bin/hlash"}},
+		{"/usr/bin/hlash run -d /etc/hlash", []string{"/usr/bin/hlash", "run", "-d", "/etc/hlash"}},
+		{"  a \t b  ", []string{"a", "b"}},
+		{`"/opt/my app/hlash" run -d "/var/lib/my data"`, []string{"/opt/my app/hlash", "run", "-d", "/var/lib/my data"}},
+		{`a "" b`, []string{"a", "", "b"}},
+		{`--name=\"x\"`, []string{`--name="x"`}},
+		{`"say \"hi\"" end`, []string{`say "hi"`, "end"}},
+		{`pre"fix suf"fix`, []string{"prefix suffix"}},
+		{`"unterminated arg`, []string{"unterminated arg"}},
+		{`trailing\`, []string{`trailing\`}},
+	}
+	for _, tt := range tests {
+		if got := splitCommandLine(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitCommandLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -1,17 +1,44 @@
 package svc
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
+	"time"
 
 	"github.com/kardianos/service"
 )
 
 const ENV_WORKING_DIRECTORY = "SVC_WORKING_DIRECTORY"
 
+const DEFAULT_STOP_TIMEOUT = 15 * time.Second //停止时等待运行函数返回的默认时间
+
 var ControlActions = [7]string{"install", "uninstall", "start", "stop", "restart", "status", "run"}
 var ControlLabels = [7]string{"安装", "卸载", "启动", "停止", "重启", "状态", "运行"}
 
+type Status = service.Status
+
+var ErrNotInstalled = service.ErrNotInstalled
+
+const (
+	StatusUnknown = service.StatusUnknown
+	StatusRunning = service.StatusRunning
+	StatusStopped = service.StatusStopped
+)
+
+// 状态的显示名称
+func StatusLabel(status Status) string {
+	switch status {
+	case StatusRunning:
+		return "运行中"
+	case StatusStopped:
+		return "已停止"
+	default:
+		return "未知"
+	}
+}
+
 func New() *Service {
 	return &Service{}
 }
@@ -28,15 +55,33 @@ type Service struct {
 	EnvVars          map[string]string
 	Option           map[string]any
 	ChRoot           string
-	Run              func()
+	Capabilities     []string                        //Linux capabilities, 如 CAP_NET_ADMIN, 仅 systemd 支持
+	NetworkOnline    bool                            //等待网络就绪后启动, 仅 systemd 支持
+	StopTimeout      time.Duration                   //停止时等待运行函数返回的时间
+	Run              func(ctx context.Context) error //运行, ctx 在服务停止时取消
 }
 
 func (s *Service) build() (sc service.Service, err error) {
 	if s.WorkingDirectory != "" {
-		s.EnvVars["SVC_WORKING_DIRECTORY"] = s.WorkingDirectory
+		if s.EnvVars == nil {
+			s.EnvVars = map[string]string{}
+		}
+		s.EnvVars[ENV_WORKING_DIRECTORY] = s.WorkingDirectory
+	}
+
+	dependencies := s.Dependencies
+	if s.NetworkOnline && service.Platform() == "linux-systemd" {
+		dependencies = append(dependencies, "After=network-online.target", "Wants=network-online.target")
+	}
+
+	if len(s.Capabilities) > 0 {
+		if s.Option == nil {
+			s.Option = map[string]any{}
+		}
+		s.Option["SystemdScript"] = systemdScript(s.Capabilities)
 	}
 
-	p := simpleProgram(func() {
+	p := &program{timeout: s.StopTimeout, run: func(ctx context.Context) (err error) {
 		if workingDirectory := os.Getenv(ENV_WORKING_DIRECTORY); workingDirectory != "" {
 			if service.Platform() == "windows-service" {
 				if workingDirectory := os.Getenv(ENV_WORKING_DIRECTORY); workingDirectory != "" {
@@ -46,18 +91,19 @@ func (s *Service) build() (sc service.Service, err error) {
 			}
 		}
 		if s.Run != nil {
-			s.Run()
+			err = s.Run(ctx)
 		}
-	})
+		return
+	}}
 
-	sc, err = service.New(&p, &service.Config{
+	sc, err = service.New(p, &service.Config{
 		Name:             s.Name,
 		DisplayName:      s.DisplayName,
 		Description:      s.Description,
 		UserName:         s.UserName,
 		Arguments:        s.Arguments,
 		Executable:       s.Executable,
-		Dependencies:     s.Dependencies,
+		Dependencies:     dependencies,
 		WorkingDirectory: s.WorkingDirectory,
 		ChRoot:           s.ChRoot, //not supported on Windows.
 		Option:           s.Option, //not supported on Windows.
@@ -127,7 +173,7 @@ func (s *Service) Control(command string) (msg string, err error) {
 		if status != service.StatusStopped {
 			err = sc.Stop()
 		} else {
-			msg = "服务已经在运行"
+			msg = "服务已经停止"
 		}
 	case "restart":
 		err = sc.Restart()
@@ -141,6 +187,8 @@ func (s *Service) Control(command string) (msg string, err error) {
 			msg = "服务运行中"
 		case service.StatusStopped:
 			msg = "服务已停止"
+		default:
+			msg = "服务状态未知"
 		}
 	}
 	return
@@ -155,15 +203,54 @@ func (s *Service) Status() (status service.Status, err error) {
 	return
 }
 
-type simpleProgram func()
+type program struct {
+	run     func(ctx context.Context) error
+	timeout time.Duration
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	err     error
+}
 
-func (p simpleProgram) Start(service.Service) (err error) {
-	if p != nil {
-		go p()
-	}
+func (p *program) Start(service.Service) (err error) {
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+		p.err = p.run(p.ctx)
+
+		//不是因为停止而退出, 以非零状态结束进程, 交给服务管理器按策略重启
+		if p.ctx.Err() == nil {
+			if p.err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", p.err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}()
 	return
 }
 
-func (p simpleProgram) Stop(service.Service) (err error) { return }
+// 取消运行并等待返回, 超时后返回错误
+func (p *program) Stop(service.Service) (err error) {
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+
+	timeout := p.timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_STOP_TIMEOUT
+	}
+
+	select {
+	case <-p.done:
+		err = p.err
+	case <-time.After(timeout):
+		err = fmt.Errorf("停止超时(%s)", timeout)
+	}
+	return
+}
 
-var _ service.Interface = simpleProgram(nil)
+var _ service.Interface = (*program)(nil)